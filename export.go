@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	network "github.com/mregulski/ppt-6-concurrent/network"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+var exporters = map[string]func(graph *network.Graph, w io.Writer) error{
+	"dot": exportDot,
+	"csv": exportCSV,
+}
+
+func exportCommand(args []string) int {
+	flags := newFlagSet("export", " network.json")
+	format := flags.String("format", "dot", "output format: dot (Graphviz) or csv (one track per row)")
+	output := flags.String("o", "", "output file (default: stdout)")
+	parseFlags(flags, args)
+	file := networkArg(flags)
+	export, ok := exporters[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return exitUsage
+	}
+	graph, ok := loadNetwork(file)
+	if !ok {
+		return exitInvalid
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	err := export(graph, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+/*
+exportDot writes the network as an undirected Graphviz graph. Junctions are nodes,
+grouped into clusters by station, tracks are edges.
+*/
+func exportDot(graph *network.Graph, w io.Writer) error {
+	fmt.Fprintf(w, "graph network {\n")
+	names := make([]string, 0, len(graph.Stations))
+	for name := range graph.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		station := graph.Stations[name]
+		fmt.Fprintf(w, "  subgraph %q {\n    label = %q;\n    %q; %q;\n  }\n",
+			"cluster_"+name, name, station.A.Name(), station.B.Name())
+	}
+	for _, junction := range graph.Junctions {
+		fmt.Fprintf(w, "  %q [shape = box];\n", junction.Name())
+	}
+	for _, track := range graph.Tracks() {
+		style := "solid"
+		if _, ok := track.(*network.WaitTrack); ok {
+			style = "dashed"
+		}
+		fmt.Fprintf(w, "  %q -- %q [label = %q, style = %s];\n", track.A().Name(), track.B().Name(), track.Name(), style)
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
+
+// exportCSV writes one row per track, with its type, ends and parameters in the units of network.json
+func exportCSV(graph *network.Graph, w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"name", "type", "a", "b", "length", "maxSpeed", "waitTime"})
+	for _, track := range graph.Tracks() {
+		row := []string{track.Name(), "", track.A().ID, track.B().ID, "", "", ""}
+		switch t := track.(type) {
+		case *network.TransitTrack:
+			row[1] = "transit"
+			row[4] = strconv.FormatFloat(t.Length, 'f', -1, 64)
+			row[5] = strconv.FormatFloat(t.MaxSpeed, 'f', -1, 64)
+		case *network.WaitTrack:
+			row[1] = "wait"
+			// hours -> minutes, as in json; 12 digits drop the rounding error of the conversion
+			row[6] = strconv.FormatFloat(t.WaitTime*60, 'g', 12, 64)
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -1,44 +1,245 @@
-package main
-
-import (
-	network "github.com/mregulski/ppt-6-concurrent/network"
-	"log"
-	"fmt"
-	"time"
-)
-
-func main() {
-	var graph *network.Graph
-	log.SetFlags(log.LstdFlags|log.Lmicroseconds)
-	if net, err := network.LoadGraph("network.json"); err != nil {
-		log.Fatal(err)
-	} else {
-		graph = net
-	}
-	fmt.Printf("%+v\n", graph.Config)
-
-	fmt.Printf("\n----------\nJunctions\n----------\n")
-	for _, junction := range graph.Junctions {
-		fmt.Printf("%v, station: %s\n", junction, graph.StationWith(junction))
-	}
-
-	fmt.Printf("\n----------\nStations\n----------\n")
-	for _, station := range graph.Stations {
-		fmt.Printf("%v\n", station)
-	}
-
-	fmt.Printf("\n----------\nTracks\n----------\n")
-	for _, track := range graph.Tracks() {
-			fmt.Printf("%v\n", track)
-	}
-
-	fmt.Printf("\n----------\nVehicles\n----------\n")
-	for _, vehicle := range graph.Vehicles {
-			fmt.Printf("%v\n", vehicle)
-	}
-
-	fmt.Printf("\n\n====================================\nStarting simulation\n====================================\n\n")
-	<-time.After(time.Second)
-	graph.Start()
-}
-
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	network "github.com/mregulski/ppt-6-concurrent/network"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+)
+
+// exit codes, shared by all commands
+const (
+	exitOK      = 0 // success
+	exitFailure = 1 // the command failed, e.g. couldn't write its output
+	exitUsage   = 2 // invalid command line
+	exitInvalid = 3 // network description couldn't be read or is invalid
+)
+
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands = map[string]command{
+	"run":      {runCommand, "run the simulation"},
+	"validate": {validateCommand, "check a network description and report all problems"},
+	"describe": {describeCommand, "print junctions, stations, tracks and vehicles of a network"},
+	"export":   {exportCommand, "convert a network description to another format"},
+	"replay":   {replayCommand, "reconstruct the state of the network from an event log"},
+	"plan":     {planCommand, "find journeys by train between two stations"},
+}
+
+var commandOrder = []string{"run", "validate", "describe", "export", "replay", "plan"}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
+		os.Exit(exitOK)
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nexit codes: %d ok, %d failure, %d invalid usage, %d invalid network\n",
+		exitOK, exitFailure, exitUsage, exitInvalid)
+	fmt.Fprintf(os.Stderr, "run '%s <command> -h' for the command's flags\n", os.Args[0])
+}
+
+/*
+newFlagSet creates a FlagSet for a command. Parsing errors print the usage
+and exit with exitUsage, -h exits with exitOK.
+*/
+func newFlagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [flags]%s\n", os.Args[0], name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) {
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		os.Exit(exitOK)
+	} else if err != nil {
+		os.Exit(exitUsage)
+	}
+}
+
+// networkArg returns the only positional argument - usually the network file, exiting if there's none
+func networkArg(flags *flag.FlagSet) string {
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	return flags.Arg(0)
+}
+
+// loadNetwork loads the network description from file, printing any problems with it
+func loadNetwork(file string) (*network.Graph, bool) {
+	graph, err := network.LoadGraph(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		return nil, false
+	}
+	return graph, true
+}
+
+func runCommand(args []string) (status int) {
+	flags := newFlagSet("run", "")
+	networkFile := flags.String("network", "network.json", "network description")
+	duration := flags.Duration("duration", 0, "simulated time to run for, e.g. 72h (overrides config.duration)")
+	seed := flags.Int64("seed", 0, "seed of the random number streams (overrides config.seed)")
+	timeScale := flags.Float64("timescale", 0, "real milliseconds per simulated hour (overrides config.timeScale)")
+	clock := flags.String("clock", "", "\"real\" or \"virtual\" (overrides config.clock)")
+	eventsFile := flags.String("events", "", "write all simulation events to this file, as JSON Lines")
+	scenarioFile := flags.String("scenario", "", "run the scripted events listed in this file")
+	quiet := flags.Bool("quiet", false, "don't log events to stderr")
+	parseFlags(flags, args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if *clock != "" && *clock != "real" && *clock != "virtual" {
+		fmt.Fprintf(os.Stderr, "invalid clock %q, must be \"real\" or \"virtual\"\n", *clock)
+		return exitUsage
+	}
+	if *duration < 0 || *timeScale < 0 {
+		fmt.Fprintln(os.Stderr, "-duration and -timescale must not be negative")
+		return exitUsage
+	}
+
+	graph, ok := loadNetwork(*networkFile)
+	if !ok {
+		return exitInvalid
+	}
+	if *scenarioFile != "" {
+		if err := graph.LoadScenario(*scenarioFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *scenarioFile, err)
+			return exitInvalid
+		}
+	}
+	if *duration > 0 {
+		graph.Config.Duration = duration.Hours()
+	}
+	if *seed != 0 {
+		graph.Config.Seed = *seed
+	}
+	if *timeScale > 0 {
+		graph.Config.TimeScale = *timeScale
+	}
+	if *clock != "" {
+		graph.Config.Clock = *clock
+	}
+	if graph.Config.Clock != "virtual" && graph.Config.TimeScale <= 0 {
+		fmt.Fprintln(os.Stderr, "-timescale must be positive with the real clock")
+		return exitUsage
+	}
+
+	if !*quiet {
+		graph.Events.Subscribe(network.EventLogger(log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds), true))
+	}
+	status = exitOK
+	if *eventsFile != "" {
+		file, err := os.Create(*eventsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		writer := network.NewEventWriter(file)
+		unsubscribe := graph.Events.Subscribe(writer.Write)
+		defer func() { // status is a named result, so a failure here changes the exit code
+			unsubscribe()
+			err := writer.Close()
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to write events to %s: %v\n", *eventsFile, err)
+				status = exitFailure
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+	summary := graph.Start(ctx)
+	fmt.Printf("%v\n", summary)
+	return status
+}
+
+func validateCommand(args []string) int {
+	flags := newFlagSet("validate", " network.json")
+	parseFlags(flags, args)
+	file := networkArg(flags)
+	if _, ok := loadNetwork(file); !ok {
+		return exitInvalid
+	}
+	fmt.Printf("%s: OK\n", file)
+	return exitOK
+}
+
+func describeCommand(args []string) int {
+	flags := newFlagSet("describe", " network.json")
+	parseFlags(flags, args)
+	graph, ok := loadNetwork(networkArg(flags))
+	if !ok {
+		return exitInvalid
+	}
+	fmt.Printf("%+v\n", graph.Config)
+
+	fmt.Printf("\n----------\nJunctions\n----------\n")
+	for _, junction := range graph.Junctions {
+		if station, ok := graph.StationLookup[junction.ID]; ok {
+			fmt.Printf("%v, station: %v\n", junction, station)
+		} else {
+			fmt.Printf("%v\n", junction)
+		}
+	}
+
+	fmt.Printf("\n----------\nStations\n----------\n")
+	names := make([]string, 0, len(graph.Stations))
+	for name := range graph.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%v\n", graph.Stations[name])
+	}
+
+	fmt.Printf("\n----------\nTracks\n----------\n")
+	for _, track := range graph.Tracks() {
+		fmt.Printf("%v\n", track)
+	}
+
+	fmt.Printf("\n----------\nVehicles\n----------\n")
+	for _, vehicle := range graph.Vehicles {
+		fmt.Printf("%v\n", vehicle)
+	}
+	return exitOK
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/*
+cargo is what a freight train picks up at one station of its route and delivers to another,
+returning empty along the rest of the route
+*/
+type cargo struct {
+	from      *Station
+	to        *Station
+	loading   time.Duration
+	unloading time.Duration
+}
+
+func (c *cargo) String() string {
+	return fmt.Sprintf("cargo{from: %s, to: %s, loading: %v, unloading: %v}",
+		c.from.name, c.to.name, c.loading, c.unloading)
+}
+
+func cargoFromJSON(raw map[string]*json.RawMessage, route []*Station, graph *Graph,
+	path string, errs *ValidationErrors) *cargo {
+
+	var rawCargo map[string]*json.RawMessage
+	if !decodeField(raw, "cargo", &rawCargo, path, errs) {
+		return nil
+	}
+	path = fieldPath(path, "cargo")
+	var c cargo
+	var from, to string
+	var loading, unloading float64
+	ok := decodeField(rawCargo, "from", &from, path, errs)
+	ok = decodeField(rawCargo, "to", &to, path, errs) && ok
+	ok = decodeField(rawCargo, "loading", &loading, path, errs) && ok
+	ok = decodeField(rawCargo, "unloading", &unloading, path, errs) && ok
+	if !ok {
+		return nil
+	}
+	for _, station := range route {
+		if station.name == from {
+			c.from = station
+		}
+		if station.name == to {
+			c.to = station
+		}
+	}
+	if c.from == nil {
+		errs.add(fieldPath(path, "from"), "station %q is not on the route", from)
+		ok = false
+	}
+	if c.to == nil {
+		errs.add(fieldPath(path, "to"), "station %q is not on the route", to)
+		ok = false
+	}
+	if ok && c.from == c.to {
+		errs.add(fieldPath(path, "to"), "must be another station than from")
+		ok = false
+	}
+	if loading < 0 {
+		errs.add(fieldPath(path, "loading"), "must not be negative")
+		ok = false
+	}
+	if unloading < 0 {
+		errs.add(fieldPath(path, "unloading"), "must not be negative")
+		ok = false
+	}
+	if !ok {
+		return nil
+	}
+	c.loading = graph.duration(loading / 60) // minutes in json -> hours
+	c.unloading = graph.duration(unloading / 60)
+	return &c
+}
+
+// handleCargo loads the freight train's cargo or unloads it, if station is where it's picked up or delivered
+func (t *Train) handleCargo(station *Station, ctx *Graph) {
+	if !t.loaded && station == t.cargo.from {
+		t.logf("Loading cargo at %s", station.name)
+		ctx.sleep(t.cargo.loading)
+		t.loaded = true
+		ctx.emit(&CargoLoaded{Train: t.id, Station: station.name})
+	} else if t.loaded && station == t.cargo.to {
+		t.logf("Unloading cargo at %s", station.name)
+		ctx.sleep(t.cargo.unloading)
+		t.loaded = false
+		ctx.emit(&CargoDelivered{Train: t.id, Station: station.name})
+	}
+}
+
+/*
+letPass keeps a freight train standing on a siding of station while faster passenger trains
+are due to leave the station before it could get to the next one, as estimated when they set off
+to the station. It waits until the last of them leaves, checking again for trains running late.
+*/
+func (t *Train) letPass(station *Station, location Location, next *Station, ctx *Graph) {
+	if track, ok := location.(*WaitTrack); !ok || !track.siding {
+		return
+	}
+	_, ahead, reachable := ctx.shortestPath(station.A, next.A, t.maxSpeed, nil)
+	if !reachable {
+		return
+	}
+	var yielded string
+	for {
+		now := ctx.Clock.Now()
+		until := now + ctx.waitTime(t.rng)
+		var passing []int
+		for _, due := range station.departuresBefore(now+ctx.duration(ahead), t.maxSpeed, ctx) {
+			if containsLocation(locations(due.train.waitTracksAt(station)), location) {
+				continue // it would have to wait for the siding itself
+			}
+			passing = append(passing, due.train.id)
+			if due.due > until {
+				until = due.due
+			}
+		}
+		if len(passing) == 0 {
+			return
+		}
+		if fmt.Sprint(passing) != yielded { // only once while waiting for the same trains
+			yielded = fmt.Sprint(passing)
+			ctx.emit(&FreightYielded{Train: t.id, Location: location.Name(), Until: until, Passing: passing})
+		}
+		ctx.sleep(until - now)
+	}
+}
+
+/*
+announce tells the station at stop idx of the route that the passenger train left it, and the next one
+when the train is due to leave it - after travelling the rest of the way there, from location from
+*/
+func (t *Train) announce(idx int, lap int, rest [][]Location, from Location, ctx *Graph) {
+	t.Route[idx].expect(expectation{train: t, left: true}, ctx)
+	next := t.nextStationIdx(idx)
+	if next == 0 {
+		lap++
+	}
+	arrival := ctx.Clock.Now() + ctx.duration(t.routeTime(rest, from))
+	t.Route[next].expect(expectation{train: t, due: t.dueAt(next, lap, arrival, ctx)}, ctx)
+}
+
+// dueAt estimates when the train leaves stop idx of its route in lap, arriving there at arrival
+func (t *Train) dueAt(idx int, lap int, arrival time.Duration, ctx *Graph) time.Duration {
+	if t.Timetable != nil {
+		if departure := t.Timetable.departure(ctx, lap, idx); departure > arrival {
+			return departure
+		}
+	}
+	return arrival
+}
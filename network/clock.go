@@ -0,0 +1,343 @@
+package network
+
+import (
+	"bytes"
+	"container/heap"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Clock is the source of simulated time. All delays in the simulation are
+expressed in simulated time and go through the Graph's Clock.
+*/
+type Clock interface {
+	// Now returns simulated time elapsed since the clock was created
+	Now() time.Duration
+	// After waits for d of simulated time to elapse and then sends the current
+	// simulated time on the returned channel
+	After(d time.Duration) <-chan time.Duration
+}
+
+/*
+realClock maps simulated time onto wall-clock time, using a fixed number of
+milliseconds per simulated hour
+*/
+type realClock struct {
+	start     time.Time
+	timeScale float64 // number of milliseconds per simulated hour
+}
+
+/*
+NewRealClock creates a Clock running in real time, where one simulated hour
+lasts timeScale milliseconds
+*/
+func NewRealClock(timeScale float64) Clock {
+	return &realClock{start: time.Now(), timeScale: timeScale}
+}
+
+func (c *realClock) Now() time.Duration {
+	return c.toSimulated(time.Since(c.start))
+}
+
+func (c *realClock) After(d time.Duration) <-chan time.Duration {
+	ch := make(chan time.Duration, 1)
+	time.AfterFunc(c.toReal(d), func() { ch <- c.Now() })
+	return ch
+}
+
+func (c *realClock) toReal(d time.Duration) time.Duration {
+	return time.Duration(d.Hours() * c.timeScale * float64(time.Millisecond))
+}
+
+func (c *realClock) toSimulated(d time.Duration) time.Duration {
+	if c.timeScale == 0 {
+		return 0
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	return time.Duration(ms / c.timeScale * float64(time.Hour))
+}
+
+/*
+virtualClock is a discrete-event clock. Time stands still while any goroutine
+in the process is able to run, and jumps straight to the next pending timer
+once all of them are blocked - see allBlocked for what counts as blocked.
+Timers fire one at a time, in order of their due time and then creation, each once
+the goroutines woken by the previous one are blocked again - so that as long as the
+simulation's goroutines only run one at a time (see settle), same-seed runs are the same.
+*/
+type virtualClock struct {
+	mu       sync.Mutex
+	now      time.Duration
+	seq      int
+	timers   timerQueue
+	wake     chan struct{}
+	quit     chan struct{}
+	runner   int    // id of the goroutine advancing the clock
+	settling []int  // ids of goroutines waiting in settle, in order of calling it
+	settles  int    // number of calls to settle so far
+	stack    []byte // buffer for goroutine dumps, used by one goroutine at a time
+	dumping  sync.Mutex
+}
+
+/*
+settleRounds is the number of times the virtual clock yields before checking if all goroutines are blocked.
+It only saves goroutine dumps while goroutines woken by a timer are still busy - a dump is taken anyway
+before advancing, so the value doesn't affect correctness.
+*/
+const settleRounds = 10
+
+/*
+NewVirtualClock creates a Clock that advances instantly to the next pending
+timer once every goroutine is blocked.
+Goroutines unrelated to the simulation that never block will prevent it from advancing.
+*/
+func NewVirtualClock() Clock {
+	c := &virtualClock{
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+		stack: make([]byte, 64*1024),
+	}
+	started := make(chan struct{})
+	go c.run(started)
+	<-started
+	return c
+}
+
+func (c *virtualClock) Now() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Duration {
+	ch := make(chan time.Duration, 1)
+	c.mu.Lock()
+	if d <= 0 {
+		ch <- c.now
+		c.mu.Unlock()
+		return ch
+	}
+	c.seq++
+	heap.Push(&c.timers, &timer{at: c.now + d, seq: c.seq, c: ch})
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return ch
+}
+
+// Stop stops advancing the clock. Pending timers will never fire.
+func (c *virtualClock) Stop() {
+	close(c.quit)
+}
+
+func (c *virtualClock) run(started chan<- struct{}) {
+	c.runner = goroutineID()
+	close(started)
+	for {
+		c.mu.Lock()
+		pending, seq, settles := len(c.timers), c.seq, c.settles
+		c.mu.Unlock()
+		if pending == 0 {
+			select {
+			case <-c.wake:
+			case <-c.quit:
+				return
+			}
+			continue
+		}
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		// checking all goroutines is expensive, so first give them a chance
+		// to run and only check once they stopped scheduling new timers
+		for i := 0; i < settleRounds; i++ {
+			runtime.Gosched()
+		}
+		c.mu.Lock()
+		settled := c.unchanged(seq, settles)
+		c.mu.Unlock()
+		if settled && c.allBlocked(nil) {
+			c.advance(seq, settles)
+		}
+	}
+}
+
+/*
+unchanged checks that no timer was created and no goroutine started settling since seq and settles
+were read, so that nothing the goroutine dump could have missed happened in between. Needs c.mu.
+*/
+func (c *virtualClock) unchanged(seq, settles int) bool {
+	return seq == c.seq && settles == c.settles && len(c.settling) == 0
+}
+
+/*
+advance moves the clock to the earliest pending timer and fires it,
+unless anything changed since seq and settles were read
+*/
+func (c *virtualClock) advance(seq, settles int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.unchanged(seq, settles) {
+		return
+	}
+	next := heap.Pop(&c.timers).(*timer)
+	c.now = next.at
+	next.c <- c.now
+}
+
+/*
+settle calls wake and then waits until every goroutine other than the calling one is blocked, without
+advancing the clock. A goroutine making another one runnable, e.g. by starting it or by waking it up
+while it keeps running itself, does it in wake to let it run on its own first. Goroutines which started
+settling earlier count as blocked - they're only waiting, for the later ones among others. The caller
+starts settling before wake, so that the goroutines it wakes up, settling in turn, count it as earlier.
+*/
+func (c *virtualClock) settle(wake func()) {
+	id := goroutineID()
+	c.mu.Lock()
+	earlier := map[int]bool{c.runner: true}
+	for _, other := range c.settling {
+		earlier[other] = true
+	}
+	c.settling = append(c.settling, id)
+	c.settles++
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.settling {
+			if other == id {
+				c.settling = append(c.settling[:i], c.settling[i+1:]...)
+				break
+			}
+		}
+	}()
+	wake()
+	for {
+		for i := 0; i < settleRounds; i++ {
+			runtime.Gosched()
+		}
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		if c.allBlocked(earlier) {
+			return
+		}
+	}
+}
+
+/*
+runnableStates are goroutine states (as reported by runtime.Stack) of goroutines that can make progress.
+Any other state counts as blocked, e.g. "chan receive", "chan send", "select", "sync.Mutex.Lock",
+"sync.WaitGroup.Wait", "semacquire", "sleep" (a real timer, which the virtual clock doesn't wait for)
+or "IO wait". Tests in clock_test.go check the ones the simulation relies on.
+*/
+var runnableStates = map[string]bool{
+	"running":           true,
+	"runnable":          true,
+	"syscall":           true,
+	"preempted":         true,
+	"copystack":         true,
+	"GC assist wait":    true,
+	"GC assist marking": true,
+}
+
+/*
+allBlocked reports whether every goroutine other than the calling one and the ignored ones is blocked,
+judging by the state in the header of each goroutine's entry in runtime.Stack, e.g. "goroutine 7 [chan receive]:".
+A goroutine waiting in os/signal's receiving loop is blocked too, even though it's in a syscall,
+and one in the clock's methods isn't - it can only be waiting for one of the clock's locks.
+Entries without a recognizable header count as blocked.
+*/
+func (c *virtualClock) allBlocked(ignored map[int]bool) bool {
+	c.dumping.Lock()
+	defer c.dumping.Unlock()
+	var buf []byte
+	for {
+		n := runtime.Stack(c.stack, true)
+		if n < len(c.stack) {
+			buf = c.stack[:n]
+			break
+		}
+		c.stack = make([]byte, 2*len(c.stack))
+	}
+	// the first entry is always the calling goroutine
+	for _, entry := range bytes.Split(buf, []byte("\n\n"))[1:] {
+		header := string(entry[:bytes.IndexByte(entry, '\n')+1])
+		start := strings.IndexByte(header, '[')
+		end := strings.IndexAny(header, ",]")
+		if start < 0 || end < start {
+			continue
+		}
+		if ignored[headerID(header)] {
+			continue
+		}
+		// os/signal's receiving loop waits for signals in a syscall, but is blocked nonetheless
+		if runnableStates[header[start+1:end]] && !bytes.Contains(entry, []byte("os/signal.signal_recv")) {
+			return false
+		}
+		// waiting for the clock's own locks only takes a moment, e.g. another goroutine settling
+		if bytes.Contains(entry, []byte("(*virtualClock).")) {
+			return false
+		}
+	}
+	return true
+}
+
+// goroutineID returns the id of the calling goroutine, as shown in goroutine dumps
+func goroutineID() int {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	return headerID(string(buf[:n]))
+}
+
+// headerID parses the goroutine id from the header of its goroutine dump entry, or returns 0
+func headerID(header string) int {
+	id := 0
+	for _, r := range strings.TrimPrefix(header, "goroutine ") {
+		if r < '0' || r > '9' {
+			break
+		}
+		id = id*10 + int(r-'0')
+	}
+	return id
+}
+
+type timer struct {
+	at  time.Duration
+	seq int
+	c   chan time.Duration
+}
+
+// timerQueue is a min-heap of timers, ordered by due time and creation order
+type timerQueue []*timer
+
+func (q timerQueue) Len() int { return len(q) }
+
+func (q timerQueue) Less(i, j int) bool {
+	if q[i].at == q[j].at {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at < q[j].at
+}
+
+func (q timerQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *timerQueue) Push(x interface{}) { *q = append(*q, x.(*timer)) }
+
+func (q *timerQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	*q = old[:n-1]
+	return t
+}
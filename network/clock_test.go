@@ -0,0 +1,190 @@
+package network
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receive waits for a virtual timer and checks the time it fired at, and the clock's time afterwards
+func receive(t *testing.T, clock Clock, timer <-chan time.Duration, want time.Duration) {
+	t.Helper()
+	if fired := <-timer; fired != want {
+		t.Errorf("timer fired at %v, want %v", fired, want)
+	}
+	if now := clock.Now(); now != want {
+		t.Errorf("Now() = %v, want %v", now, want)
+	}
+}
+
+func TestVirtualClockStartsAtZero(t *testing.T) {
+	clock := NewVirtualClock()
+	defer clock.(*virtualClock).Stop()
+	if now := clock.Now(); now != 0 {
+		t.Fatalf("Now() = %v, want 0", now)
+	}
+	receive(t, clock, clock.After(0), 0)
+	receive(t, clock, clock.After(-time.Hour), 0)
+}
+
+func TestVirtualClockFiresTimersInOrder(t *testing.T) {
+	clock := NewVirtualClock()
+	defer clock.(*virtualClock).Stop()
+	third := clock.After(3 * time.Hour)
+	first := clock.After(time.Hour)
+	second := clock.After(90 * time.Minute)
+	receive(t, clock, first, time.Hour)
+	receive(t, clock, second, 90*time.Minute)
+	// timers set later count from the current time
+	fourth := clock.After(2 * time.Hour)
+	receive(t, clock, third, 3*time.Hour)
+	receive(t, clock, fourth, 3*time.Hour+30*time.Minute)
+}
+
+func TestVirtualClockFiresSimultaneousTimersOneAtATime(t *testing.T) {
+	clock := NewVirtualClock()
+	defer clock.(*virtualClock).Stop()
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		i := i
+		timer := clock.After(time.Hour)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-timer
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}()
+	}
+	later := clock.After(time.Hour + time.Nanosecond)
+	receive(t, clock, later, time.Hour+time.Nanosecond)
+	wg.Wait()
+	// each timer fires once the goroutine woken by the previous one is done, in order of creation
+	for i, fired := range order {
+		if fired != i {
+			t.Fatalf("timers fired in order %v, want the order of creation", order)
+		}
+	}
+}
+
+func TestVirtualClockSettleWaitsForWokenGoroutines(t *testing.T) {
+	clock := NewVirtualClock().(*virtualClock)
+	defer clock.Stop()
+	var finished atomic.Bool
+	block := make(chan struct{})
+	defer close(block)
+	clock.settle(func() {
+		go func() {
+			for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+			}
+			finished.Store(true)
+			<-block
+		}()
+	})
+	if !finished.Load() {
+		t.Fatal("settle returned while a goroutine was running")
+	}
+}
+
+func TestVirtualClockSettleWaitsForGoroutinesSettlingInTurn(t *testing.T) {
+	clock := NewVirtualClock().(*virtualClock)
+	defer clock.Stop()
+	var started, finished atomic.Bool
+	block := make(chan struct{})
+	defer close(block)
+	clock.settle(func() {
+		go func() {
+			// settles right away, possibly before the outer call gets to look at it
+			clock.settle(func() {
+				go func() {
+					for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+					}
+					finished.Store(true)
+					<-block
+				}()
+			})
+			started.Store(true)
+			<-block
+		}()
+	})
+	if !started.Load() || !finished.Load() {
+		t.Fatal("settle returned while a goroutine it woke up was still settling")
+	}
+}
+
+func TestVirtualClockWakesEveryWaitingGoroutine(t *testing.T) {
+	clock := NewVirtualClock()
+	defer clock.(*virtualClock).Stop()
+	var wg sync.WaitGroup
+	fired := make([]time.Duration, 10)
+	for i := range fired {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fired[i] = <-clock.After(time.Duration(i%3+1) * time.Hour)
+		}()
+	}
+	wg.Wait()
+	for i, at := range fired {
+		if want := time.Duration(i%3+1) * time.Hour; at != want {
+			t.Errorf("goroutine %d woke at %v, want %v", i, at, want)
+		}
+	}
+	if now := clock.Now(); now != 3*time.Hour {
+		t.Errorf("Now() = %v, want %v", now, 3*time.Hour)
+	}
+}
+
+func TestVirtualClockWaitsForRunningGoroutines(t *testing.T) {
+	clock := NewVirtualClock()
+	defer clock.(*virtualClock).Stop()
+	var finished, advanced atomic.Bool
+	go func() {
+		for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+			if clock.Now() != 0 {
+				advanced.Store(true)
+			}
+		}
+		finished.Store(true)
+	}()
+	receive(t, clock, clock.After(time.Hour), time.Hour)
+	if advanced.Load() || !finished.Load() {
+		t.Fatal("the clock advanced while a goroutine was running")
+	}
+}
+
+func TestVirtualClockAdvancesPastBlockedGoroutines(t *testing.T) {
+	clock := NewVirtualClock()
+	defer clock.(*virtualClock).Stop()
+	release, never, pending := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	defer close(release)
+	defer func() { <-pending }()
+	var mu sync.Mutex
+	mu.Lock()
+	defer mu.Unlock()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	defer wg.Done()
+	blocked := []func(){
+		func() { <-release },
+		func() { pending <- struct{}{} },
+		func() {
+			select {
+			case <-release:
+			case <-never:
+			}
+		},
+		func() { mu.Lock(); mu.Unlock() },
+		func() { wg.Wait() },
+		func() { time.Sleep(time.Second) }, // waiting for a real timer
+	}
+	for _, block := range blocked {
+		go block()
+	}
+	receive(t, clock, clock.After(time.Hour), time.Hour)
+}
@@ -0,0 +1,192 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// what to do about detected deadlocks
+const (
+	deadlocksReport  = "report"
+	deadlocksBackoff = "backoff"
+)
+
+/*
+waitUpdate is sent by position handlers to the deadlock detector whenever a vehicle is
+denied entry or reservation because of another vehicle (holder > 0), gets what it asked for
+or is denied for another reason (holder == 0), or leaves or releases a location (leaving is true).
+*/
+type waitUpdate struct {
+	vehicle  int
+	holder   int
+	location string
+	leaving  bool
+}
+
+// waitEdge is an edge of the wait-for graph: a vehicle waiting at location for holder
+type waitEdge struct {
+	holder   int
+	location string
+}
+
+/*
+detectDeadlocks maintains the wait-for graph of vehicles and looks for cycles in it,
+until the simulation is stopped. Each cycle is reported once, and with the "backoff" policy
+the lowest-priority vehicle in it is told to give up what it's waiting for. If the same cycle
+forms again, the next vehicle in it is told to back off, until all of them tried.
+*/
+func (graph *Graph) detectDeadlocks() {
+	vehicles := make(map[int]Vehicle)
+	for _, vehicle := range graph.Vehicles {
+		vehicles[vehicle.ID()] = vehicle
+	}
+	waits := make(map[int]waitEdge)
+	reported := make(map[int]string)       // vehicle -> the reported cycle it's still waiting in
+	tried := make(map[string]map[int]bool) // cycle -> vehicles already told to back off from it
+	forget := func(vehicle int) {
+		delete(waits, vehicle)
+		delete(reported, vehicle)
+	}
+	for {
+		var update waitUpdate
+		select {
+		case update = <-graph.waits:
+		case <-graph.done:
+			return
+		}
+		if update.leaving {
+			for vehicle, edge := range waits {
+				if edge.holder == update.vehicle && edge.location == update.location {
+					forget(vehicle)
+				}
+			}
+			continue
+		}
+		if update.holder == 0 {
+			forget(update.vehicle)
+			continue
+		}
+		edge, waiting := waits[update.vehicle]
+		if waiting && edge == (waitEdge{update.holder, update.location}) {
+			continue // still waiting, already checked
+		} else if waiting {
+			forget(update.vehicle)
+		}
+		waits[update.vehicle] = waitEdge{update.holder, update.location}
+		cycle := waitCycle(waits, update.vehicle)
+		if cycle == nil {
+			continue
+		}
+		event := &DeadlockDetected{}
+		for _, id := range cycle {
+			event.Vehicles = append(event.Vehicles, id)
+			event.Locations = append(event.Locations, waits[id].location)
+		}
+		key := deadlockKey(event)
+		candidates := cycle
+		if alreadyReported(cycle, reported, key) {
+			// the same cycle formed again after backing off - try another vehicle, if any is left
+			if graph.Config.Deadlocks != deadlocksBackoff {
+				continue
+			}
+			candidates = nil
+			for _, id := range cycle {
+				if !tried[key][id] {
+					candidates = append(candidates, id)
+				}
+			}
+			if len(candidates) == 0 {
+				continue
+			}
+		} else {
+			tried[key] = make(map[int]bool)
+		}
+		for _, id := range cycle {
+			reported[id] = key
+		}
+		if graph.Config.Deadlocks == deadlocksBackoff {
+			victim := lowestPriority(candidates, vehicles)
+			event.Victim = victim
+			tried[key][victim] = true
+			vehicles[victim].backOff()
+			delete(waits, victim)
+		}
+		graph.emit(event)
+	}
+}
+
+// waitCycle returns the vehicles in a cycle of the wait-for graph going through start, or nil if there's none
+func waitCycle(waits map[int]waitEdge, start int) []int {
+	cycle := []int{start}
+	for current := waits[start].holder; current != start; current = waits[current].holder {
+		if _, waiting := waits[current]; !waiting || len(cycle) > len(waits) {
+			return nil
+		}
+		cycle = append(cycle, current)
+	}
+	return cycle
+}
+
+// deadlockKey identifies a cycle regardless of the vehicle it was found from
+func deadlockKey(event *DeadlockDetected) string {
+	parts := make([]string, len(event.Vehicles))
+	for i, id := range event.Vehicles {
+		parts[i] = fmt.Sprintf("%d@%s", id, event.Locations[i])
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// alreadyReported checks whether all of the cycle's vehicles are still waiting in the same reported cycle
+func alreadyReported(cycle []int, reported map[int]string, key string) bool {
+	for _, id := range cycle {
+		if reported[id] != key {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+lowestPriority picks the vehicle to back off: trains carry passengers so they go before
+repair crews, which go before freight trains. Among vehicles of the same kind the one
+with the lower id goes first.
+*/
+func lowestPriority(cycle []int, vehicles map[int]Vehicle) int {
+	victim := cycle[0]
+	for _, id := range cycle[1:] {
+		if rank, victimRank := priority(vehicles[id]), priority(vehicles[victim]); rank != victimRank {
+			if rank < victimRank {
+				victim = id
+			}
+		} else if id > victim {
+			victim = id
+		}
+	}
+	return victim
+}
+
+// priority ranks kinds of vehicles for resolving deadlocks, higher first
+func priority(vehicle Vehicle) int {
+	switch vehicleType(vehicle) {
+	case "train":
+		return 2
+	case "repair":
+		return 1
+	}
+	return 0
+}
+
+/*
+updateWaits sends update to the deadlock detector, unless the simulation stops first,
+and lets the detector tell whoever has to back off before going on
+*/
+func (graph *Graph) updateWaits(update waitUpdate) {
+	graph.settle(func() {
+		select {
+		case graph.waits <- update:
+		case <-graph.done:
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWaitCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		waits map[int]waitEdge
+		start int
+		cycle string
+	}{
+		{"no waits", map[int]waitEdge{1: {0, ""}}, 1, "[]"},
+		{"chain", map[int]waitEdge{1: {2, "A"}, 2: {3, "B"}}, 1, "[]"},
+		{"two vehicles", map[int]waitEdge{1: {2, "A"}, 2: {1, "B"}}, 2, "[2 1]"},
+		{"three vehicles", map[int]waitEdge{1: {2, "A"}, 2: {3, "B"}, 3: {1, "C"}}, 3, "[3 1 2]"},
+		{"cycle not going through start", map[int]waitEdge{1: {2, "A"}, 2: {3, "B"}, 3: {2, "C"}}, 1, "[]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if cycle := fmt.Sprint(waitCycle(test.waits, test.start)); cycle != test.cycle {
+				t.Errorf("waitCycle() = %s, want %s", cycle, test.cycle)
+			}
+		})
+	}
+}
+
+// deadlockVehicles are trains 1 and 2, repair crew 3 and freight train 4
+func deadlockVehicles() []Vehicle {
+	vehicle := func(id int) baseVehicle { return baseVehicle{id: id, backoff: make(chan bool, 1)} }
+	return []Vehicle{
+		&Train{baseVehicle: vehicle(1)},
+		&Train{baseVehicle: vehicle(2)},
+		&RepairVehicle{baseVehicle: vehicle(3)},
+		&Train{baseVehicle: vehicle(4), cargo: &cargo{}},
+	}
+}
+
+func TestLowestPriority(t *testing.T) {
+	vehicles := make(map[int]Vehicle)
+	for _, vehicle := range deadlockVehicles() {
+		vehicles[vehicle.ID()] = vehicle
+	}
+	tests := []struct {
+		cycle  []int
+		victim int
+	}{
+		{[]int{1, 2}, 2},
+		{[]int{2, 1}, 2},
+		{[]int{1, 3}, 3},
+		{[]int{3, 4}, 4},
+		{[]int{4, 1, 3}, 4},
+		{[]int{3}, 3},
+	}
+	for _, test := range tests {
+		if victim := lowestPriority(test.cycle, vehicles); victim != test.victim {
+			t.Errorf("lowestPriority(%v) = %d, want %d", test.cycle, victim, test.victim)
+		}
+	}
+}
+
+func TestDetectDeadlocks(t *testing.T) {
+	wait := func(vehicle, holder int, location string) waitUpdate {
+		return waitUpdate{vehicle: vehicle, holder: holder, location: location}
+	}
+	tests := []struct {
+		name      string
+		policy    string
+		updates   []waitUpdate
+		reports   string // vehicles@locations and the victim of every DeadlockDetected, separated by spaces
+		backedOff string // vehicles told to back off
+	}{
+		{"no cycle", deadlocksBackoff, []waitUpdate{wait(1, 2, "A"), wait(2, 3, "B")}, "", "[]"},
+		{"reported", deadlocksReport, []waitUpdate{wait(1, 2, "A"), wait(2, 1, "B")}, "[2 1]@[B A]/0", "[]"},
+		{"reported once", deadlocksReport, []waitUpdate{wait(1, 2, "A"), wait(2, 1, "B"), wait(2, 1, "B"),
+			wait(1, 2, "A")}, "[2 1]@[B A]/0", "[]"},
+		{"train with a higher id backs off", deadlocksBackoff, []waitUpdate{wait(1, 2, "A"), wait(2, 1, "B")},
+			"[2 1]@[B A]/2", "[2]"},
+		{"crew backs off for a train", deadlocksBackoff, []waitUpdate{wait(3, 1, "A"), wait(1, 3, "B")},
+			"[1 3]@[B A]/3", "[3]"},
+		{"freight train backs off for a crew", deadlocksBackoff, []waitUpdate{wait(3, 4, "A"), wait(4, 3, "B")},
+			"[4 3]@[B A]/4", "[4]"},
+		{"the next vehicle backs off when the cycle forms again", deadlocksBackoff,
+			[]waitUpdate{wait(1, 2, "A"), wait(2, 1, "B"), wait(2, 1, "B"), wait(2, 1, "B")},
+			"[2 1]@[B A]/2 [2 1]@[B A]/1", "[1 2]"},
+		{"holder leaving", deadlocksBackoff,
+			[]waitUpdate{wait(1, 2, "A"), {vehicle: 2, location: "A", leaving: true}, wait(2, 1, "B")}, "", "[]"},
+		{"waiting vehicle let in", deadlocksBackoff, []waitUpdate{wait(1, 2, "A"), wait(1, 0, "A"), wait(2, 1, "B")},
+			"", "[]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph := &Graph{Config: &graphConfig{Deadlocks: test.policy}, Events: &EventBus{},
+				Vehicles: deadlockVehicles(), waits: make(chan waitUpdate)}
+			graph.Clock = NewVirtualClock()
+			defer graph.Clock.(*virtualClock).Stop()
+			done := make(chan struct{})
+			graph.done = done
+			var reports []string // delivered by the time it's unsubscribed
+			unsubscribe := graph.Events.Subscribe(func(event Event) {
+				if deadlock, ok := event.(*DeadlockDetected); ok {
+					reports = append(reports, fmt.Sprintf("%v@%v/%d", deadlock.Vehicles, deadlock.Locations, deadlock.Victim))
+				}
+			})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				graph.detectDeadlocks()
+			}()
+			for _, update := range test.updates {
+				graph.waits <- update
+			}
+			graph.waits <- waitUpdate{} // once it's received, the last update is handled
+			close(done)
+			<-stopped
+			unsubscribe()
+
+			if got := strings.Join(reports, " "); got != test.reports {
+				t.Errorf("reported %q, want %q", got, test.reports)
+			}
+			var backedOff []int
+			for _, vehicle := range graph.Vehicles {
+				if vehicle.(interface{ backedOff() bool }).backedOff() {
+					backedOff = append(backedOff, vehicle.ID())
+				}
+			}
+			if got := fmt.Sprint(backedOff); got != test.backedOff {
+				t.Errorf("backed off %s, want %s", got, test.backedOff)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package network
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+demand is the flow of passengers between a pair of stations, in passengers per hour.
+The rate changes during the day according to a profile of 24 hourly multipliers.
+*/
+type demand struct {
+	from    *Station
+	to      *Station
+	rate    float64
+	profile []float64 // nil for a constant rate
+}
+
+func (graph *Graph) loadDemand(rawTrips []map[string]*json.RawMessage, errs *ValidationErrors) {
+	for i, rawTrip := range rawTrips {
+		if d := demandFromJSON(rawTrip, graph, indexPath("demand", i), errs); d != nil {
+			graph.demand = append(graph.demand, d)
+		}
+	}
+}
+
+func demandFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) *demand {
+
+	var d demand
+	var from, to string
+	ok := decodeField(raw, "from", &from, path, errs)
+	ok = decodeField(raw, "to", &to, path, errs) && ok
+	ok = decodeField(raw, "rate", &d.rate, path, errs) && ok
+	if !ok {
+		return nil
+	}
+	if d.from = graph.Stations[from]; d.from == nil {
+		errs.add(fieldPath(path, "from"), "unknown station %q", from)
+		ok = false
+	}
+	if d.to = graph.Stations[to]; d.to == nil {
+		errs.add(fieldPath(path, "to"), "unknown station %q", to)
+		ok = false
+	}
+	if ok && d.from == d.to {
+		errs.add(fieldPath(path, "to"), "must be another station than from")
+		ok = false
+	}
+	if d.rate <= 0 {
+		errs.add(fieldPath(path, "rate"), "must be positive")
+		ok = false
+	}
+	d.profile = graph.Config.DemandProfile
+	var profile []float64
+	if decodeOptionalField(raw, "profile", &profile, path, errs) {
+		ok = validateProfile(profile, fieldPath(path, "profile"), errs) && ok
+		d.profile = profile
+	}
+	if !ok {
+		return nil
+	}
+	return &d
+}
+
+// validateProfile checks a daily profile of demand: 24 hourly multipliers, not all of them 0
+func validateProfile(profile []float64, path string, errs *ValidationErrors) bool {
+	if len(profile) != 24 {
+		errs.add(path, "expected 24 hourly values, got %d", len(profile))
+		return false
+	}
+	peak := 0.0
+	for i, factor := range profile {
+		if factor < 0 {
+			errs.add(indexPath(path, i), "must not be negative")
+			return false
+		}
+		peak = math.Max(peak, factor)
+	}
+	if peak == 0 {
+		errs.add(path, "must not be 0 all day")
+		return false
+	}
+	return true
+}
+
+// factor returns the multiplier of the demand's rate at simulated time now
+func (d *demand) factor(now time.Duration) float64 {
+	if d.profile == nil {
+		return 1
+	}
+	return d.profile[int(now.Hours())%24]
+}
+
+// peak returns the highest multiplier of the demand's rate during the day
+func (d *demand) peak() float64 {
+	peak := 1.0
+	if d.profile != nil {
+		peak = 0
+		for _, factor := range d.profile {
+			peak = math.Max(peak, factor)
+		}
+	}
+	return peak
+}
+
+/*
+generatePassengers spawns passengers wanting to travel between the demand's stations,
+at random times following its rate, until the simulation is stopped
+*/
+func (graph *Graph) generatePassengers(d *demand, rng *rand.Rand) {
+	journey, ok := graph.journey(d.from, d.to)
+	if !ok {
+		graph.logf("No trains running between %s and %s, no passengers", d.from.name, d.to.name)
+		return
+	}
+	ideal := graph.idealTime(d.from, d.to, journey)
+	peak := d.peak()
+	for {
+		// thinning: candidates come at the peak rate, each one accepted with the current share of it
+		graph.sleep(graph.duration(rng.ExpFloat64() / (d.rate * peak)))
+		if rng.Float64()*peak < d.factor(graph.Clock.Now()) {
+			graph.spawn(func() { graph.carry(d, journey, ideal) })
+		}
+	}
+}
+
+/*
+idealTime is how long a journey between two stations would take with no waiting, transfers
+or detours: along the shortest path, at the speed of the fastest train of the journey
+*/
+func (graph *Graph) idealTime(from *Station, to *Station, journey []ride) time.Duration {
+	speed := 0.0
+	for _, leg := range journey {
+		for _, train := range leg.trains {
+			speed = math.Max(speed, train.MaxSpeed())
+		}
+	}
+	_, travelTime, _ := graph.shortestPath(from.A, to.A, speed, nil)
+	return graph.duration(travelTime)
+}
+
+// carry takes a single passenger along journey and records how it went
+func (graph *Graph) carry(d *demand, journey []ride, ideal time.Duration) {
+	start := graph.Clock.Now()
+	waiting := graph.travelBy(journey, 0)
+	total := graph.Clock.Now() - start
+	lost := total - ideal
+	if lost < 0 {
+		lost = 0
+	}
+	graph.reportJourney(&JourneyCompleted{From: d.from.name, To: d.to.name, Rides: len(journey),
+		Duration: total, Waiting: waiting, Lost: lost})
+}
+
+// reportJourney publishes a finished journey and counts it in the summary
+func (graph *Graph) reportJourney(journey *JourneyCompleted) {
+	graph.emit(journey)
+	select {
+	case graph.journeys <- journey:
+	case <-graph.done:
+	}
+}
@@ -0,0 +1,38 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDemandJourneys(t *testing.T) {
+	events, summary := runShuttle(t)
+	journeys := 0
+	var journeyTime, waitingTime time.Duration
+	for _, event := range events {
+		journey, ok := event.(*JourneyCompleted)
+		if !ok {
+			continue
+		}
+		journeys++
+		journeyTime += journey.Duration
+		waitingTime += journey.Waiting
+		if journey.From != "A" || journey.To != "B" || journey.Rides != 1 {
+			t.Errorf("got %+v, want a single ride from A to B", journey)
+		}
+		if journey.Waiting >= journey.Duration || journey.Lost > journey.Duration {
+			t.Errorf("got %+v, waiting and time lost should be parts of the journey", journey)
+		}
+	}
+	if journeys == 0 {
+		t.Fatal("no passenger arrived")
+	}
+	if summary.Journeys != journeys || summary.JourneyTime != journeyTime || summary.WaitingTime != waitingTime {
+		t.Errorf("summary counted %d journeys taking %v, with %v of waiting, want %d, %v and %v",
+			summary.Journeys, summary.JourneyTime, summary.WaitingTime, journeys, journeyTime, waitingTime)
+	}
+	if len(summary.Loads) != 1 || summary.Loads[0].Train != 1 || summary.Loads[0].MaxPassengers != 10 ||
+		summary.Loads[0].Refused == 0 {
+		t.Errorf("got loads %v, want train 1 full and leaving passengers behind during the repair", summary.Loads)
+	}
+}
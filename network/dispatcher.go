@@ -0,0 +1,246 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+dispatcher keeps a queue of open emergencies and assigns each of them to the best
+available repair crew - the one that can get there the fastest. The most severe
+emergencies are assigned first and among them, the ones disrupting the most trains.
+Planned maintenance comes after all emergencies.
+Crews that can't reach their target give it back, to be reassigned to another crew.
+*/
+type dispatcher struct {
+	emergencies chan emergency
+	planned     chan *maintenanceWindow
+	ready       chan crewReady
+	abandoned   chan crewAssignment
+	claims      chan crewClaim
+}
+
+// crewReady is sent by a crew that finished its previous assignment
+type crewReady struct {
+	crew     *RepairVehicle
+	position Location
+	wait     bool             // wait for an emergency, rather than give up if there's none queued
+	reply    chan *assignment // nil if there's nothing to do
+}
+
+// crewAssignment is an emergency given back by the crew it was assigned to
+type crewAssignment struct {
+	crew       *RepairVehicle
+	assignment *assignment
+}
+
+// crewClaim is sent by a crew that came across a failed element on its way
+type crewClaim struct {
+	handler requestHandler
+	reply   chan *assignment // nil if the emergency isn't queued (yet)
+}
+
+// assignment is an open emergency or planned maintenance, possibly assigned to a crew
+type assignment struct {
+	emergency
+	window     *maintenanceWindow      // nil for emergencies
+	disruption int                     // number of trains using the failed location
+	excluded   map[*RepairVehicle]bool // crews that already failed to reach it
+}
+
+// severity of the assignment's failure, 0 for maintenance
+func (a *assignment) severity() int {
+	if a.window != nil {
+		return 0
+	}
+	return a.failure.Severity
+}
+
+// before checks whether a should be assigned before b
+func (a *assignment) before(b *assignment) bool {
+	if a.severity() != b.severity() {
+		return a.severity() > b.severity()
+	}
+	return a.disruption > b.disruption
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		emergencies: make(chan emergency),
+		planned:     make(chan *maintenanceWindow),
+		ready:       make(chan crewReady),
+		abandoned:   make(chan crewAssignment),
+		claims:      make(chan crewClaim),
+	}
+}
+
+// run handles the dispatcher's communication, until the simulation is stopped
+func (d *dispatcher) run(graph *Graph) {
+	var queue []*assignment
+	idle := make(map[*RepairVehicle]crewReady)
+	usage := graph.trainsUsing()
+	for {
+		select {
+		case e := <-d.emergencies:
+			queue = append(queue, &assignment{
+				emergency:  e,
+				disruption: usage[e.location],
+				excluded:   make(map[*RepairVehicle]bool),
+			})
+		case window := <-d.planned:
+			queue = append(queue, &assignment{
+				emergency:  emergency{location: window.location, handler: window.location},
+				window:     window,
+				disruption: usage[window.location],
+				excluded:   make(map[*RepairVehicle]bool),
+			})
+		case ready := <-d.ready:
+			idle[ready.crew] = ready
+		case given := <-d.abandoned:
+			given.assignment.excluded[given.crew] = true
+			queue = append([]*assignment{given.assignment}, queue...)
+		case claim := <-d.claims:
+			var claimed *assignment
+			for i, open := range queue {
+				if open.handler == claim.handler && open.window == nil {
+					claimed = open
+					queue = append(queue[:i], queue[i+1:]...)
+					break
+				}
+			}
+			graph.settle(func() { claim.reply <- claimed })
+		case <-graph.done:
+			return
+		}
+		sort.SliceStable(queue, func(i, j int) bool { return queue[i].before(queue[j]) })
+		queue = d.assignIdle(queue, idle, graph)
+		for _, crew := range crewsByID(idle) {
+			if ready := idle[crew]; !ready.wait {
+				delete(idle, crew)
+				graph.settle(func() { ready.reply <- nil })
+			}
+		}
+	}
+}
+
+// assignIdle assigns queued emergencies, in order, to the nearest ready crews
+func (d *dispatcher) assignIdle(queue []*assignment, idle map[*RepairVehicle]crewReady,
+	graph *Graph) []*assignment {
+
+	remaining := queue[:0]
+	for _, open := range queue {
+		if open.window != nil && graph.Clock.Now() >= open.window.end {
+			graph.missMaintenance(0, open.location.Name())
+			continue
+		}
+		if len(idle) > 0 && allExcluded(open, idle) {
+			// every idle crew already tried, let them try again
+			open.excluded = make(map[*RepairVehicle]bool)
+		}
+		var best *crewReady
+		bestTime := math.Inf(1)
+		for crew, ready := range idle {
+			if open.excluded[crew] {
+				continue
+			}
+			_, travelTime, reachable := graph.shortestPath(ready.position, open.location, crew.maxSpeed, nil)
+			if !reachable {
+				travelTime = math.MaxFloat64
+			}
+			if best == nil || travelTime < bestTime ||
+				(travelTime == bestTime && crew.id < best.crew.id) {
+				ready := ready
+				best, bestTime = &ready, travelTime
+			}
+		}
+		if best == nil {
+			remaining = append(remaining, open)
+			continue
+		}
+		if open.window != nil {
+			graph.emit(&MaintenanceAssigned{Crew: best.crew.id, Location: open.location.Name()})
+		} else {
+			graph.emit(&RepairAssigned{Crew: best.crew.id, Target: emergencyName(open.emergency)})
+		}
+		delete(idle, best.crew)
+		graph.settle(func() { best.reply <- open })
+	}
+	return remaining
+}
+
+// crewsByID lists the idle crews ordered by id, so they're told to carry on in the same order every run
+func crewsByID(idle map[*RepairVehicle]crewReady) []*RepairVehicle {
+	crews := make([]*RepairVehicle, 0, len(idle))
+	for crew := range idle {
+		crews = append(crews, crew)
+	}
+	sort.Slice(crews, func(i, j int) bool { return crews[i].id < crews[j].id })
+	return crews
+}
+
+func allExcluded(open *assignment, idle map[*RepairVehicle]crewReady) bool {
+	for crew := range idle {
+		if !open.excluded[crew] {
+			return false
+		}
+	}
+	return true
+}
+
+// emergencyName returns name of the failed element
+func emergencyName(e emergency) string {
+	if train, ok := e.handler.(*Train); ok {
+		return fmt.Sprintf("Train #%d", train.id)
+	}
+	return e.location.Name()
+}
+
+/*
+next reports crew ready for another assignment at position, and returns it.
+If wait is false and there's no emergency waiting, it returns nil immediately.
+*/
+func (d *dispatcher) next(crew *RepairVehicle, position Location, wait bool, graph *Graph) *assignment {
+	reply := make(chan *assignment, 1)
+	select {
+	case d.ready <- crewReady{crew, position, wait, reply}:
+	case <-graph.done:
+		graph.exit()
+	}
+	select {
+	case open := <-reply:
+		return open
+	case <-graph.done:
+		graph.exit()
+		return nil
+	}
+}
+
+// abandon gives back an emergency crew couldn't reach, to be assigned to another crew
+func (d *dispatcher) abandon(crew *RepairVehicle, open *assignment, graph *Graph) {
+	select {
+	case d.abandoned <- crewAssignment{crew, open}:
+	case <-graph.done:
+		graph.exit()
+	}
+}
+
+/*
+claim takes the queued emergency of handler, which a crew came across on its way.
+Returns nil if it isn't queued - e.g. it's already assigned to another crew.
+*/
+func (d *dispatcher) claim(handler requestHandler, graph *Graph) *assignment {
+	reply := make(chan *assignment, 1)
+	select {
+	case d.claims <- crewClaim{handler, reply}:
+	case <-graph.done:
+		graph.exit()
+	}
+	select {
+	case claimed := <-reply:
+		return claimed
+	case <-graph.done:
+		graph.exit()
+		return nil
+	}
+}
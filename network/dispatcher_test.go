@@ -0,0 +1,118 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDispatcherAssignsNearestCrews(t *testing.T) {
+	graph := parseGraph(t, routingNetwork)
+	graph.Clock = NewVirtualClock()
+	defer graph.Clock.(*virtualClock).Stop()
+	crews := make(map[int]*RepairVehicle)
+	for id := 1; id <= 3; id++ {
+		crews[id] = &RepairVehicle{baseVehicle: baseVehicle{id: id, maxSpeed: 100}}
+	}
+	type open struct {
+		at         string // junction id
+		severity   int
+		disruption int
+		excluded   []int
+	}
+	tests := []struct {
+		name     string
+		idle     map[int]string // crew id -> junction id it's at
+		queue    []open
+		assigned string // crew:junction for every assignment, in order of crews
+		left     string // junctions of the emergencies left in the queue
+	}{
+		{"nearest crew", map[int]string{1: "1", 2: "4"}, []open{{at: "3", severity: 1}}, "2:3", ""},
+		{"equally near crews", map[int]string{1: "1", 2: "4"}, []open{{at: "2", severity: 1}}, "1:2", ""},
+		{"crew that already failed to get there", map[int]string{1: "1", 2: "4"},
+			[]open{{at: "3", severity: 1, excluded: []int{2}}}, "1:3", ""},
+		{"only crews that already failed to get there", map[int]string{2: "4"},
+			[]open{{at: "3", severity: 1, excluded: []int{2}}}, "2:3", ""},
+		{"no idle crews", nil, []open{{at: "3", severity: 1}}, "", "3"},
+		{"most severe first", map[int]string{1: "1"},
+			[]open{{at: "2", severity: 1, disruption: 5}, {at: "3", severity: 2}}, "1:3", "2"},
+		{"most disruptive first", map[int]string{1: "1"},
+			[]open{{at: "2", severity: 2, disruption: 1}, {at: "3", severity: 2, disruption: 2}}, "1:3", "2"},
+		{"every emergency to another crew", map[int]string{1: "1", 2: "4", 3: "3"},
+			[]open{{at: "2", severity: 2}, {at: "3", severity: 1}, {at: "1", severity: 3}}, "1:1,2:2,3:3", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idle := make(map[*RepairVehicle]crewReady)
+			for id, at := range test.idle {
+				idle[crews[id]] = crewReady{crew: crews[id], position: graph.Junction(at),
+					reply: make(chan *assignment, 1)}
+			}
+			replies := make(map[int]chan *assignment)
+			for crew, ready := range idle {
+				replies[crew.id] = ready.reply
+			}
+			var queue []*assignment
+			for _, o := range test.queue {
+				junction := graph.Junction(o.at)
+				a := &assignment{
+					emergency:  emergency{location: junction, handler: junction, failure: &failureType{Severity: o.severity}},
+					disruption: o.disruption,
+					excluded:   make(map[*RepairVehicle]bool),
+				}
+				for _, id := range o.excluded {
+					a.excluded[crews[id]] = true
+				}
+				queue = append(queue, a)
+			}
+			sort.SliceStable(queue, func(i, j int) bool { return queue[i].before(queue[j]) })
+
+			remaining := newDispatcher().assignIdle(queue, idle, graph)
+			var assigned, left []string
+			for id := 1; id <= len(crews); id++ {
+				select {
+				case a := <-replies[id]:
+					assigned = append(assigned, fmt.Sprintf("%d:%s", id, a.handler.(*Junction).ID))
+				default:
+				}
+			}
+			for _, a := range remaining {
+				left = append(left, a.handler.(*Junction).ID)
+			}
+			if got := strings.Join(assigned, ","); got != test.assigned {
+				t.Errorf("assigned %q, want %q", got, test.assigned)
+			}
+			if got := strings.Join(left, ","); got != test.left {
+				t.Errorf("left %q in the queue, want %q", got, test.left)
+			}
+		})
+	}
+}
+
+func TestDispatcherClaims(t *testing.T) {
+	graph := parseGraph(t, routingNetwork)
+	graph.Clock = NewVirtualClock()
+	defer graph.Clock.(*virtualClock).Stop()
+	done := make(chan struct{})
+	defer close(done)
+	graph.done = done
+	d := newDispatcher()
+	go d.run(graph)
+
+	failed, other := graph.Junction("2"), graph.Junction("3")
+	d.emergencies <- emergency{location: failed, handler: failed, failure: graph.Config.defaultFailure()}
+	if claimed := d.claim(other, graph); claimed != nil {
+		t.Errorf("claimed %s, which isn't queued", emergencyName(claimed.emergency))
+	}
+	if claimed := d.claim(failed, graph); claimed == nil || claimed.handler != failed {
+		t.Fatalf("claim(%s) = %v, want the queued emergency", failed.Name(), claimed)
+	}
+	if claimed := d.claim(failed, graph); claimed != nil {
+		t.Errorf("claimed %s twice", failed.Name())
+	}
+	crew := &RepairVehicle{baseVehicle: baseVehicle{id: 1, maxSpeed: 100}}
+	if open := d.next(crew, other, false, graph); open != nil {
+		t.Errorf("crew got %s, already claimed", emergencyName(open.emergency))
+	}
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// eventTypes maps names used in event logs to constructors of the corresponding events
+var eventTypes = map[string]func() Event{
+	"VehicleEntered":      func() Event { return &VehicleEntered{} },
+	"VehicleLeft":         func() Event { return &VehicleLeft{} },
+	"EntryDenied":         func() Event { return &EntryDenied{} },
+	"ReservationMade":     func() Event { return &ReservationMade{} },
+	"ReservationReleased": func() Event { return &ReservationReleased{} },
+	"FailureRaised":       func() Event { return &FailureRaised{} },
+	"RepairAssigned":      func() Event { return &RepairAssigned{} },
+	"RepairStarted":       func() Event { return &RepairStarted{} },
+	"RepairFinished":      func() Event { return &RepairFinished{} },
+	"TaskCreated":         func() Event { return &TaskCreated{} },
+	"WorkerAssigned":      func() Event { return &WorkerAssigned{} },
+	"WorkerBoarded":       func() Event { return &WorkerBoarded{} },
+	"WorkerAlighted":      func() Event { return &WorkerAlighted{} },
+	"TrainLoaded":         func() Event { return &TrainLoaded{} },
+	"JourneyCompleted":    func() Event { return &JourneyCompleted{} },
+	"CargoLoaded":         func() Event { return &CargoLoaded{} },
+	"CargoDelivered":      func() Event { return &CargoDelivered{} },
+	"FreightYielded":      func() Event { return &FreightYielded{} },
+	"TaskStarted":         func() Event { return &TaskStarted{} },
+	"TaskFinished":        func() Event { return &TaskFinished{} },
+	"StopServed":          func() Event { return &StopServed{} },
+	"Rerouted":            func() Event { return &Rerouted{} },
+	"LocationClosed":      func() Event { return &LocationClosed{} },
+	"LocationReopened":    func() Event { return &LocationReopened{} },
+	"TrainDelayed":        func() Event { return &TrainDelayed{} },
+	"MaintenanceAssigned": func() Event { return &MaintenanceAssigned{} },
+	"MaintenanceStarted":  func() Event { return &MaintenanceStarted{} },
+	"MaintenanceFinished": func() Event { return &MaintenanceFinished{} },
+	"MaintenanceMissed":   func() Event { return &MaintenanceMissed{} },
+	"DeadlockDetected":    func() Event { return &DeadlockDetected{} },
+	"Message":             func() Event { return &Message{} },
+}
+
+// eventTypeName returns the name of event's type, as used in event logs
+func eventTypeName(event Event) string {
+	return reflect.TypeOf(event).Elem().Name()
+}
+
+/*
+EventWriter writes events as JSON Lines - one JSON object per line, with the
+event's type in the "type" field. Its Write method can be used as an EventBus subscriber.
+*/
+type EventWriter struct {
+	mu  sync.Mutex
+	out *bufio.Writer
+	err error
+}
+
+// NewEventWriter creates an EventWriter writing to w
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{out: bufio.NewWriter(w)}
+}
+
+// Write writes a single event. After the first error, all events are ignored.
+func (w *EventWriter) Write(event Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		w.err = err
+		return
+	}
+	// splice the type into the object: {"type":"...",<fields>}
+	_, w.err = fmt.Fprintf(w.out, "{\"type\":%q,%s\n", eventTypeName(event), data[1:])
+}
+
+// Close flushes buffered events and returns the first error encountered while writing
+func (w *EventWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.out.Flush()
+	}
+	return w.err
+}
+
+// ReadEvents reads an event log written by EventWriter
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var header struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		newEvent, ok := eventTypes[header.Type]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown event type %q", line, header.Type)
+		}
+		event := newEvent()
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,404 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something observable that happened in the simulation
+type Event interface {
+	// Time returns the simulated time at which the event happened
+	Time() time.Duration
+	stamp(at time.Duration)
+}
+
+// Timestamp is embedded in all events to implement Event
+type Timestamp struct {
+	At time.Duration `json:"time"` // simulated time, in nanoseconds
+}
+
+// Time - implements Event.Time
+func (t *Timestamp) Time() time.Duration {
+	return t.At
+}
+
+func (t *Timestamp) stamp(at time.Duration) {
+	t.At = at
+}
+
+// VehicleEntered is emitted when a vehicle enters a Location
+type VehicleEntered struct {
+	Timestamp
+	Vehicle  int    `json:"vehicle"`
+	Location string `json:"location"`
+}
+
+// VehicleLeft is emitted when a vehicle leaves a Location
+type VehicleLeft struct {
+	Timestamp
+	Vehicle  int    `json:"vehicle"`
+	Location string `json:"location"`
+}
+
+// EntryDenied is emitted when a Location refuses to let a vehicle in
+type EntryDenied struct {
+	Timestamp
+	Vehicle  int    `json:"vehicle"`
+	Location string `json:"location"`
+	Reason   string `json:"reason"`           // "failing", "closed", "reserved", "occupied" or "priority"
+	Holder   int    `json:"holder,omitempty"` // id of the vehicle reserving, occupying or waiting for the location
+}
+
+// ReservationMade is emitted when a vehicle reserves a Location
+type ReservationMade struct {
+	Timestamp
+	Vehicle  int    `json:"vehicle"`
+	Location string `json:"location"`
+}
+
+// ReservationReleased is emitted when a vehicle releases its reservation of a Location
+type ReservationReleased struct {
+	Timestamp
+	Vehicle  int    `json:"vehicle"`
+	Location string `json:"location"`
+}
+
+// FailureRaised is emitted when a network element or a vehicle breaks down
+type FailureRaised struct {
+	Timestamp
+	Target   string `json:"target"`   // name of the failing element
+	Location string `json:"location"` // where the failure happened
+	Kind     string `json:"kind"`     // type of the failure
+	Severity int    `json:"severity"`
+}
+
+// RepairAssigned is emitted when the dispatcher assigns a failed element to a repair crew
+type RepairAssigned struct {
+	Timestamp
+	Crew   int    `json:"crew"`
+	Target string `json:"target"`
+}
+
+// RepairStarted is emitted when a repair crew starts repairing a failed element
+type RepairStarted struct {
+	Timestamp
+	Crew   int    `json:"crew"`
+	Target string `json:"target"`
+}
+
+// RepairFinished is emitted when a failed element is back online
+type RepairFinished struct {
+	Timestamp
+	Crew   int    `json:"crew"`
+	Target string `json:"target"`
+}
+
+// TaskCreated is emitted when a new task appears at a Station
+type TaskCreated struct {
+	Timestamp
+	Task     int     `json:"task"`
+	Station  string  `json:"station"`
+	Workers  int     `json:"workers"`
+	Duration float64 `json:"duration"` // in hours
+}
+
+// WorkerAssigned is emitted when the job board sends a worker to a task
+type WorkerAssigned struct {
+	Timestamp
+	Worker  int    `json:"worker"`
+	Task    int    `json:"task"`
+	Station string `json:"station"`
+}
+
+// WorkerBoarded is emitted when a worker gets on a train
+type WorkerBoarded struct {
+	Timestamp
+	Worker  int    `json:"worker"`
+	Train   int    `json:"train"`
+	Station string `json:"station"`
+}
+
+// WorkerAlighted is emitted when a worker gets off a train
+type WorkerAlighted struct {
+	Timestamp
+	Worker  int    `json:"worker"`
+	Train   int    `json:"train"`
+	Station string `json:"station"`
+}
+
+// TaskStarted is emitted when all workers assigned to a task arrived at its station
+type TaskStarted struct {
+	Timestamp
+	Task    int    `json:"task"`
+	Station string `json:"station"`
+	Workers int    `json:"workers"`
+}
+
+// TaskFinished is emitted when a task is done and its workers go home
+type TaskFinished struct {
+	Timestamp
+	Task    int    `json:"task"`
+	Station string `json:"station"`
+}
+
+// TrainLoaded is emitted when a train is about to leave a station of its route, after boarding
+type TrainLoaded struct {
+	Timestamp
+	Train      int    `json:"train"`
+	Station    string `json:"station"`
+	Passengers int    `json:"passengers"`
+	Capacity   int    `json:"capacity"`
+	Refused    int    `json:"refused,omitempty"` // passengers left behind, because the train is full
+}
+
+// JourneyCompleted is emitted when a passenger arrives at its destination, see demand
+type JourneyCompleted struct {
+	Timestamp
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Rides    int           `json:"rides"`
+	Duration time.Duration `json:"duration"` // in nanoseconds, since the passenger came to the station
+	Waiting  time.Duration `json:"waiting"`  // in nanoseconds, for trains
+	Lost     time.Duration `json:"lost"`     // in nanoseconds, compared to a direct journey with no waiting
+}
+
+// CargoLoaded is emitted when a freight train is loaded with its cargo
+type CargoLoaded struct {
+	Timestamp
+	Train   int    `json:"train"`
+	Station string `json:"station"`
+}
+
+// CargoDelivered is emitted when a freight train is unloaded at the cargo's destination
+type CargoDelivered struct {
+	Timestamp
+	Train   int    `json:"train"`
+	Station string `json:"station"`
+}
+
+// FreightYielded is emitted when a freight train waits on a siding to let faster trains pass
+type FreightYielded struct {
+	Timestamp
+	Train    int           `json:"train"`
+	Location string        `json:"location"` // the siding
+	Until    time.Duration `json:"until"`    // simulated time, in nanoseconds
+	Passing  []int         `json:"passing"`  // the faster trains
+}
+
+// StopServed is emitted when a timetabled train departs from a station of its route
+type StopServed struct {
+	Timestamp
+	Train              int           `json:"train"`
+	Stop               int           `json:"stop"` // index in the route
+	Station            string        `json:"station"`
+	ScheduledArrival   time.Duration `json:"scheduledArrival"`
+	Arrival            time.Duration `json:"arrival"`
+	ScheduledDeparture time.Duration `json:"scheduledDeparture"`
+	Departure          time.Duration `json:"departure"`
+}
+
+// Rerouted is emitted when a train takes a detour around failing locations
+type Rerouted struct {
+	Timestamp
+	Train    int      `json:"train"`
+	Station  string   `json:"station"`  // the train's next stop
+	Avoiding []string `json:"avoiding"` // names of the failing locations
+	Detour   float64  `json:"detour"`   // additional travel time, in hours
+}
+
+// LocationClosed is emitted when an open location is closed for planned maintenance or by a scenario
+type LocationClosed struct {
+	Timestamp
+	Location string        `json:"location"`
+	Until    time.Duration `json:"until"` // simulated time this closure ends, in nanoseconds
+}
+
+// LocationReopened is emitted when a closed location is open again, once all of its closures ended
+type LocationReopened struct {
+	Timestamp
+	Location string `json:"location"`
+}
+
+// TrainDelayed is emitted when a train is held on purpose, e.g. by a scenario
+type TrainDelayed struct {
+	Timestamp
+	Train    int           `json:"train"`
+	Location string        `json:"location"`
+	Delay    time.Duration `json:"delay"` // in nanoseconds
+}
+
+// MaintenanceAssigned is emitted when the dispatcher sends a repair crew to planned maintenance
+type MaintenanceAssigned struct {
+	Timestamp
+	Crew     int    `json:"crew"`
+	Location string `json:"location"`
+}
+
+// MaintenanceStarted is emitted when a repair crew starts maintaining a closed location
+type MaintenanceStarted struct {
+	Timestamp
+	Crew     int    `json:"crew"`
+	Location string `json:"location"`
+}
+
+// MaintenanceFinished is emitted when a repair crew finishes maintenance, at the end of its window
+type MaintenanceFinished struct {
+	Timestamp
+	Crew     int    `json:"crew"`
+	Location string `json:"location"`
+}
+
+// MaintenanceMissed is emitted when planned maintenance didn't happen, e.g. no crew made it in time
+type MaintenanceMissed struct {
+	Timestamp
+	Crew     int    `json:"crew,omitempty"` // the crew that was assigned to it, if any
+	Location string `json:"location"`
+}
+
+// DeadlockDetected is emitted when vehicles wait for each other in a cycle
+type DeadlockDetected struct {
+	Timestamp
+	Vehicles  []int    `json:"vehicles"`         // each one waits for the next, the last one for the first
+	Locations []string `json:"locations"`        // where each of the vehicles waits to get in
+	Victim    int      `json:"victim,omitempty"` // the vehicle told to back off, if any
+}
+
+// Message is a free-form diagnostic message
+type Message struct {
+	Timestamp
+	Source  string `json:"source"`
+	Vehicle int    `json:"vehicle,omitempty"` // id of the vehicle the message comes from, if any
+	Text    string `json:"text"`
+}
+
+/*
+EventBus distributes simulation events to any number of subscribers.
+Each subscriber receives all events in order, in its own goroutine, so a slow
+subscriber never blocks the simulation.
+*/
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers []*subscriber
+}
+
+/*
+Subscribe registers handler to be called with every subsequent event.
+The returned function unsubscribes it, after delivering all events published so far.
+*/
+func (bus *EventBus) Subscribe(handler func(Event)) (unsubscribe func()) {
+	sub := &subscriber{handler: handler, signal: make(chan struct{}, 1), done: make(chan struct{})}
+	bus.mu.Lock()
+	bus.subscribers = append(bus.subscribers, sub)
+	bus.mu.Unlock()
+	go sub.run()
+	return func() {
+		bus.mu.Lock()
+		for i, s := range bus.subscribers {
+			if s == sub {
+				bus.subscribers = append(bus.subscribers[:i], bus.subscribers[i+1:]...)
+				break
+			}
+		}
+		bus.mu.Unlock()
+		sub.close()
+	}
+}
+
+// Publish sends event to all subscribers
+func (bus *EventBus) Publish(event Event) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	for _, sub := range bus.subscribers {
+		sub.push(event)
+	}
+}
+
+// Flush waits until all events published so far are delivered to all subscribers
+func (bus *EventBus) Flush() {
+	bus.mu.Lock()
+	subscribers := append([]*subscriber{}, bus.subscribers...)
+	bus.mu.Unlock()
+	for _, sub := range subscribers {
+		sub.flush()
+	}
+}
+
+// subscriber queues events for a single handler
+type subscriber struct {
+	handler func(Event)
+	mu      sync.Mutex
+	queue   []Event
+	busy    bool // handling a batch of events
+	closed  bool
+	signal  chan struct{}
+	done    chan struct{}
+	idle    []chan struct{} // waiting for the queue to be empty
+}
+
+func (sub *subscriber) push(event Event) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, event)
+	sub.mu.Unlock()
+	sub.wake()
+}
+
+func (sub *subscriber) wake() {
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *subscriber) close() {
+	sub.mu.Lock()
+	sub.closed = true
+	sub.mu.Unlock()
+	sub.wake()
+	<-sub.done
+}
+
+func (sub *subscriber) flush() {
+	idle := make(chan struct{})
+	sub.mu.Lock()
+	if len(sub.queue) == 0 && !sub.busy {
+		sub.mu.Unlock()
+		return
+	}
+	sub.idle = append(sub.idle, idle)
+	sub.mu.Unlock()
+	<-idle
+}
+
+func (sub *subscriber) run() {
+	defer close(sub.done)
+	for {
+		sub.mu.Lock()
+		batch := sub.queue
+		sub.queue = nil
+		sub.busy = len(batch) > 0
+		if len(batch) == 0 {
+			for _, idle := range sub.idle {
+				close(idle)
+			}
+			sub.idle = nil
+		}
+		closed := sub.closed
+		sub.mu.Unlock()
+
+		for _, event := range batch {
+			sub.handler(event)
+		}
+		if len(batch) == 0 {
+			if closed {
+				return
+			}
+			<-sub.signal
+		}
+	}
+}
+
+// emit stamps event with the current simulated time and publishes it
+func (graph *Graph) emit(event Event) {
+	event.stamp(graph.Clock.Now())
+	graph.Events.Publish(event)
+}
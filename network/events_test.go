@@ -0,0 +1,52 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	tests := []struct {
+		name        string
+		subscribe   int // number of events published before subscribing
+		unsubscribe int // ... and before unsubscribing, or -1 to flush instead
+		received    string
+	}{
+		{"from the start", 0, -1, "1 2 3 4 5"},
+		{"later", 2, -1, "3 4 5"},
+		{"until unsubscribed", 0, 3, "1 2 3"},
+		{"in between", 1, 4, "2 3 4"},
+		{"nothing", 5, 5, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bus := &EventBus{}
+			var received []string // delivered by the time it's flushed or unsubscribed
+			var unsubscribe func()
+			block := make(chan struct{})
+			for i := 0; i <= 5; i++ {
+				if i == test.subscribe {
+					unsubscribe = bus.Subscribe(func(event Event) {
+						<-block // a slow subscriber doesn't hold up publishing
+						received = append(received, event.(*Message).Text)
+					})
+				}
+				if i == test.unsubscribe {
+					close(block)
+					unsubscribe()
+				}
+				if i < 5 {
+					bus.Publish(&Message{Text: fmt.Sprint(i + 1)})
+				}
+			}
+			if test.unsubscribe < 0 {
+				close(block)
+				bus.Flush()
+			}
+			if got := strings.Join(received, " "); got != test.received {
+				t.Errorf("received %q, want %q", got, test.received)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package network
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+/*
+failureModel decides when an element fails next. Once it fails, no further failures are
+generated until it's repaired.
+*/
+type failureModel interface {
+	// untilFailure returns simulated time from now until the next failure,
+	// or false if the element never fails again
+	untilFailure(now time.Duration, rng *rand.Rand) (time.Duration, bool)
+	// maintained returns the model after preventive maintenance at given time,
+	// which lowers failure rates by reduction (a fraction)
+	maintained(at time.Duration, reduction float64) failureModel
+}
+
+// maxFailureHorizon limits how far ahead hourly failure models look for the next failure
+const maxFailureHorizon = 10 * 365 * 24 * time.Hour
+
+/*
+constantFailures fails with the same probability every hour, after a grace
+period of 2 hours since the start or the last repair
+*/
+type constantFailures struct {
+	rate float64 // probability of a failure per hour
+}
+
+func (m *constantFailures) untilFailure(now time.Duration, rng *rand.Rand) (time.Duration, bool) {
+	if m.rate <= 0 {
+		return 0, false
+	}
+	if m.rate >= 1 {
+		return 3 * time.Hour, true
+	}
+	// number of hourly attempts until one succeeds, geometrically distributed
+	attempts := math.Ceil(math.Log(1-rng.Float64()) / math.Log(1-m.rate))
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := time.Duration((2 + attempts) * float64(time.Hour))
+	return wait, wait < maxFailureHorizon
+}
+
+func (m *constantFailures) maintained(at time.Duration, reduction float64) failureModel {
+	return &constantFailures{m.rate * (1 - reduction)}
+}
+
+/*
+weibullFailures models aging: the time to failure follows a Weibull distribution
+with given shape and scale, conditioned on the element's age. Shape above 1 makes
+older elements fail more often. Repairs don't make elements younger.
+*/
+type weibullFailures struct {
+	shape float64
+	scale float64 // in hours
+	age   float64 // age of the element when the simulation starts, in hours; negative if it's renewed later
+}
+
+func (m *weibullFailures) untilFailure(now time.Duration, rng *rand.Rand) (time.Duration, bool) {
+	age := m.age + now.Hours()
+	u := 1 - rng.Float64() // in (0, 1]
+	failureAge := m.scale * math.Pow(math.Pow(age/m.scale, m.shape)-math.Log(u), 1/m.shape)
+	return time.Duration((failureAge - age) * float64(time.Hour)), true
+}
+
+// maintained makes the element as good as new, regardless of reduction
+func (m *weibullFailures) maintained(at time.Duration, reduction float64) failureModel {
+	return &weibullFailures{m.shape, m.scale, -at.Hours()}
+}
+
+/*
+dailyFailures fails with probability depending on the hour of the day, e.g. more often
+during rush hours. The simulation starts at midnight.
+*/
+type dailyFailures struct {
+	rates [24]float64 // probability of a failure during each hour of the day
+}
+
+func (m *dailyFailures) untilFailure(now time.Duration, rng *rand.Rand) (time.Duration, bool) {
+	hour := now.Truncate(time.Hour) + time.Hour
+	for ; hour-now < maxFailureHorizon; hour += time.Hour {
+		rate := m.rates[int(hour.Hours())%24]
+		if rate > 0 && rng.Float64() < rate {
+			return hour - now, true
+		}
+	}
+	return 0, false
+}
+
+func (m *dailyFailures) maintained(at time.Duration, reduction float64) failureModel {
+	model := &dailyFailures{}
+	for i, rate := range m.rates {
+		model.rates[i] = rate * (1 - reduction)
+	}
+	return model
+}
+
+// scriptedFailures fails at given simulated times, unless the element is already failing then
+type scriptedFailures struct {
+	at []time.Duration // sorted
+}
+
+func (m *scriptedFailures) untilFailure(now time.Duration, rng *rand.Rand) (time.Duration, bool) {
+	i := sort.Search(len(m.at), func(i int) bool { return m.at[i] >= now })
+	if i == len(m.at) {
+		return 0, false
+	}
+	return m.at[i] - now, true
+}
+
+// maintained doesn't change scripted failures
+func (m *scriptedFailures) maintained(at time.Duration, reduction float64) failureModel {
+	return m
+}
+
+// failureModelConfig is the JSON description of a failure model
+type failureModelConfig struct {
+	Model string    // "constant", "weibull", "daily" or "scripted"
+	Rate  float64   // constant: probability of a failure per hour
+	Shape float64   // weibull
+	Scale float64   // weibull, in hours
+	Age   float64   // weibull, in hours
+	Rates []float64 // daily: 24 hourly probabilities
+	At    []float64 // scripted: simulated hours of the failures
+}
+
+// build validates the description and creates the model, or returns nil if it's invalid
+func (c *failureModelConfig) build(path string, errs *ValidationErrors) failureModel {
+	probability := func(name string, p float64) bool {
+		if p < 0 || p > 1 {
+			errs.add(fieldPath(path, name), "must be a probability between 0 and 1")
+			return false
+		}
+		return true
+	}
+	switch c.Model {
+	case "constant":
+		if probability("rate", c.Rate) {
+			return &constantFailures{c.Rate}
+		}
+	case "weibull":
+		ok := true
+		if c.Shape <= 0 {
+			errs.add(fieldPath(path, "shape"), "must be positive")
+			ok = false
+		}
+		if c.Scale <= 0 {
+			errs.add(fieldPath(path, "scale"), "must be positive")
+			ok = false
+		}
+		if c.Age < 0 {
+			errs.add(fieldPath(path, "age"), "must not be negative")
+			ok = false
+		}
+		if ok {
+			return &weibullFailures{c.Shape, c.Scale, c.Age}
+		}
+	case "daily":
+		if len(c.Rates) != 24 {
+			errs.add(fieldPath(path, "rates"), "expected 24 hourly rates, got %d", len(c.Rates))
+			return nil
+		}
+		model := &dailyFailures{}
+		ok := true
+		for i, rate := range c.Rates {
+			ok = probability(indexPath("rates", i), rate) && ok
+			model.rates[i] = rate
+		}
+		if ok {
+			return model
+		}
+	case "scripted":
+		model := &scriptedFailures{}
+		ok := true
+		for i, at := range c.At {
+			if at < 0 {
+				errs.add(indexPath(fieldPath(path, "at"), i), "must not be negative")
+				ok = false
+			}
+			model.at = append(model.at, time.Duration(at*float64(time.Hour)))
+		}
+		sort.Slice(model.at, func(i, j int) bool { return model.at[i] < model.at[j] })
+		if ok {
+			return model
+		}
+	default:
+		errs.add(fieldPath(path, "model"), "unknown failure model %q (expected constant, weibull, daily or scripted)",
+			c.Model)
+	}
+	return nil
+}
+
+// failureModelFromJSON decodes the optional failure model of a single element
+func failureModelFromJSON(raw map[string]*json.RawMessage, path string, errs *ValidationErrors) failureModel {
+	var config failureModelConfig
+	if !decodeOptionalField(raw, "failureModel", &config, path, errs) {
+		return nil
+	}
+	return config.build(fieldPath(path, "failureModel"), errs)
+}
+
+// modelledElement is an element which may have its own failure model
+type modelledElement interface {
+	ownFailureModel() failureModel
+}
+
+/*
+failureModelOf returns the failure model of an element: its own one if it has one,
+otherwise the one configured for its kind, or a constant rate of Config.FailureRate
+*/
+func (graph *Graph) failureModelOf(element requestHandler) failureModel {
+	if e, ok := element.(modelledElement); ok && e.ownFailureModel() != nil {
+		return e.ownFailureModel()
+	}
+	if model, ok := graph.Config.failureModels[elementKind(element)]; ok {
+		return model
+	}
+	return &constantFailures{graph.Config.FailureRate}
+}
+
+// buildFailureModels validates failure models configured per kind of element
+func (config *graphConfig) buildFailureModels(errs *ValidationErrors) {
+	config.failureModels = make(map[string]failureModel)
+	kinds := make([]string, 0, len(config.FailureModels))
+	for kind := range config.FailureModels {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds) // report problems in the same order every time
+	for _, kind := range kinds {
+		model := config.FailureModels[kind]
+		path := fieldPath("config.failureModels", kind)
+		if kind != junctionElement && kind != trackElement && kind != trainElement {
+			errs.add(path, "unknown element %q", kind)
+			continue
+		}
+		if model == nil {
+			errs.add(path, "must be an object")
+			continue
+		}
+		if built := model.build(path, errs); built != nil {
+			config.failureModels[kind] = built
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailureModelsWithFixedSeed(t *testing.T) {
+	rushHour := &dailyFailures{}
+	for i := range rushHour.rates {
+		rushHour.rates[i] = 0.1
+	}
+	rushHour.rates[3] = 0.9
+	onlyAt3 := &dailyFailures{}
+	onlyAt3.rates[3] = 1
+	scripted := &scriptedFailures{[]time.Duration{2 * time.Hour, 5 * time.Hour}}
+	tests := []struct {
+		name  string
+		model failureModel
+		now   float64 // in hours
+		want  float64 // hours until the failure
+		fails bool
+	}{
+		{"constant", &constantFailures{0.1}, 0, 11, true},
+		{"constant later", &constantFailures{0.1}, 100, 11, true},
+		{"constant never", &constantFailures{0}, 0, 0, false},
+		{"constant certain", &constantFailures{1}, 0, 3, true},
+		{"constant maintained", (&constantFailures{0.2}).maintained(0, 0.5), 0, 11, true},
+		{"weibull", &weibullFailures{1.5, 3000, 2000}, 0, 1882.637461077, true},
+		{"weibull older", &weibullFailures{1.5, 3000, 2000}, 100, 1854.962911232, true},
+		{"weibull as good as new", (&weibullFailures{1.5, 3000, 2000}).maintained(10*time.Hour, 0), 10,
+			2854.234467461, true},
+		{"daily", rushHour, 0, 3, true},
+		{"daily from the middle of an hour", onlyAt3, 1.5, 1.5, true},
+		{"daily never", &dailyFailures{}, 0, 0, false},
+		{"scripted", scripted, 3, 2, true},
+		{"scripted now", scripted, 2, 0, true},
+		{"scripted over", scripted, 6, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Duration(test.now * float64(time.Hour))
+			until, fails := test.model.untilFailure(now, rand.New(rand.NewSource(1)))
+			if fails != test.fails || math.Abs(until.Hours()-test.want) > 1e-6 {
+				t.Errorf("untilFailure(%vh) = %vh, %v, want %vh, %v", test.now, until.Hours(), fails, test.want, test.fails)
+			}
+		})
+	}
+}
+
+func TestFailureModelValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		paths  []string // of the reported errors, in order
+	}{
+		{"valid", `{"track": {"model": "constant", "rate": 0.1}, "junction": {"model": "scripted", "at": [5, 2]},
+			"train": {"model": "weibull", "shape": 1.5, "scale": 100}}`, nil},
+		{"unknown model", `{"track": {"model": "poisson"}}`, []string{"config.failureModels.track.model"}},
+		{"unknown element", `{"station": {"model": "constant"}}`, []string{"config.failureModels.station"}},
+		{"not an object", `{"track": null}`, []string{"config.failureModels.track"}},
+		{"rate above 1", `{"track": {"model": "constant", "rate": 2}}`, []string{"config.failureModels.track.rate"}},
+		{"weibull parameters", `{"track": {"model": "weibull", "shape": 0, "scale": -1, "age": -1}}`,
+			[]string{"config.failureModels.track.shape", "config.failureModels.track.scale",
+				"config.failureModels.track.age"}},
+		{"daily rates", `{"track": {"model": "daily", "rates": [0.1]}}`, []string{"config.failureModels.track.rates"}},
+		{"negative time", `{"track": {"model": "scripted", "at": [1, -1]}}`,
+			[]string{"config.failureModels.track.at[1]"}},
+		{"several elements", `{"train": {"model": "weibull", "shape": 1}, "junction": {"model": "constant", "rate": -1}}`,
+			[]string{"config.failureModels.junction.rate", "config.failureModels.train.scale"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &graphConfig{}
+			if err := json.Unmarshal([]byte(test.config), &config.FailureModels); err != nil {
+				t.Fatal(err)
+			}
+			errs := ValidationErrors{}
+			config.buildFailureModels(&errs)
+			var paths []string
+			for _, err := range errs {
+				paths = append(paths, err.Path)
+			}
+			if strings.Join(paths, ", ") != strings.Join(test.paths, ", ") {
+				t.Errorf("errors at %v, want %v:\n%v", paths, test.paths, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package network
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// kinds of elements that may fail
+const (
+	junctionElement = "junction"
+	trackElement    = "track"
+	trainElement    = "train"
+)
+
+/*
+failureType describes a kind of failure, e.g. a signal fault or a broken rail.
+Failures with higher Severity are repaired first.
+*/
+type failureType struct {
+	Name       string
+	Elements   []string // kinds of elements it may happen to
+	Weight     float64  // relative frequency among failures of the same element, 1 by default
+	Severity   int
+	RepairTime float64 // in hours
+}
+
+// defaultFailure is used for elements not covered by any of the configured failure types
+func (config *graphConfig) defaultFailure() *failureType {
+	return &failureType{Name: "failure", Weight: 1, Severity: 1, RepairTime: config.RepairTime}
+}
+
+// failureTypes returns failure types that may happen to the kind of element
+func (config *graphConfig) failureTypes(element string) []*failureType {
+	var types []*failureType
+	for _, kind := range config.Failures {
+		for _, e := range kind.Elements {
+			if e == element {
+				types = append(types, kind)
+			}
+		}
+	}
+	return types
+}
+
+// randomFailure picks the type of a failure of element, according to the weights
+func (config *graphConfig) randomFailure(element string, rng *rand.Rand) *failureType {
+	types := config.failureTypes(element)
+	if len(types) == 0 {
+		return config.defaultFailure()
+	}
+	total := 0.0
+	for _, kind := range types {
+		total += kind.Weight
+	}
+	x := rng.Float64() * total
+	for _, kind := range types {
+		if x < kind.Weight {
+			return kind
+		}
+		x -= kind.Weight
+	}
+	return types[len(types)-1]
+}
+
+// failureNamed returns the failure type called name that may happen to element, or nil if there's none
+func (config *graphConfig) failureNamed(name string, element string) *failureType {
+	for _, kind := range config.failureTypes(element) {
+		if kind.Name == name {
+			return kind
+		}
+	}
+	return nil
+}
+
+// elementKind returns the kind of element a failing handler is
+func elementKind(handler requestHandler) string {
+	switch handler.(type) {
+	case *Junction:
+		return junctionElement
+	case Track:
+		return trackElement
+	}
+	return trainElement
+}
+
+// validateFailures checks the configured failure types
+func (config *graphConfig) validateFailures(errs *ValidationErrors) {
+	names := make(map[string]bool)
+	for i, kind := range config.Failures {
+		path := indexPath("config.failures", i)
+		if kind == nil {
+			errs.add(path, "must be an object")
+			continue
+		}
+		if kind.Name == "" {
+			errs.add(fieldPath(path, "name"), "missing required field")
+		} else if names[kind.Name] {
+			errs.add(fieldPath(path, "name"), "duplicate failure type %q", kind.Name)
+		}
+		names[kind.Name] = true
+		if len(kind.Elements) == 0 {
+			errs.add(fieldPath(path, "elements"), "must list at least one of %q, %q or %q",
+				junctionElement, trackElement, trainElement)
+		}
+		for j, e := range kind.Elements {
+			if e != junctionElement && e != trackElement && e != trainElement {
+				errs.add(indexPath(fieldPath(path, "elements"), j), "unknown element %q", e)
+			}
+		}
+		if kind.Weight == 0 {
+			kind.Weight = 1
+		} else if kind.Weight < 0 {
+			errs.add(fieldPath(path, "weight"), "must be positive")
+		}
+		if kind.Severity < 1 {
+			errs.add(fieldPath(path, "severity"), "must be at least 1")
+		}
+		if kind.RepairTime <= 0 {
+			errs.add(fieldPath(path, "repairTime"), "must be positive")
+		}
+	}
+}
+
+func (kind *failureType) String() string {
+	return fmt.Sprintf("%s (severity %d)", kind.Name, kind.Severity)
+}
@@ -0,0 +1,193 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+jobBoard collects tasks created at stations and assigns each of them, in order, to as many
+idle workers as it needs - the ones with the fewest rides from home to the task's station.
+A task waits until enough workers are idle, and starts once all of them arrive.
+*/
+type jobBoard struct {
+	posted chan *job
+	ready  chan workerReady
+}
+
+// job is a task created at a station, tracked until it's done
+type job struct {
+	task
+	id       int
+	station  *Station
+	arrivals chan int      // ids of workers arriving at the station
+	absent   chan int      // ids of workers unable to get there
+	finished chan struct{} // receives once for each worker at the station, when the task is done
+}
+
+// workerReady is sent by an idle worker, waiting at home for a job
+type workerReady struct {
+	worker *worker
+	reply  chan *job
+}
+
+func newJobBoard() *jobBoard {
+	return &jobBoard{
+		posted: make(chan *job),
+		ready:  make(chan workerReady),
+	}
+}
+
+// run handles the job board's communication, until the simulation is stopped
+func (b *jobBoard) run(graph *Graph) {
+	var queue []*job
+	idle := make(map[*worker]chan *job)
+	nextID := 1
+	for {
+		select {
+		case j := <-b.posted:
+			j.id = nextID
+			nextID++
+			graph.emit(&TaskCreated{Task: j.id, Station: j.station.name, Workers: j.workers, Duration: j.duration})
+			graph.reportTask(false)
+			queue = append(queue, j)
+		case ready := <-b.ready:
+			idle[ready.worker] = ready.reply
+		case <-graph.done:
+			return
+		}
+		queue = b.assignIdle(queue, idle, graph)
+	}
+}
+
+/*
+assignIdle assigns queued tasks, in order, to idle workers. Tasks more workers could never
+get to are dropped, and the first one that has to wait for workers blocks the rest of the queue.
+*/
+func (b *jobBoard) assignIdle(queue []*job, idle map[*worker]chan *job, graph *Graph) []*job {
+	for len(queue) > 0 {
+		j := queue[0]
+		if graph.workersReaching(j.station) < j.workers {
+			graph.emit(&Message{Source: "Jobs", Text: "Not enough workers can get to " + j.station.name +
+				", dropping task"})
+			queue = queue[1:]
+			continue
+		}
+		var candidates []*worker
+		rides := make(map[*worker]int)
+		fromHome := make(map[*Station][]ride) // journeys are the same for workers living together
+		for w := range idle {
+			journey, ok := fromHome[w.home]
+			if !ok {
+				if journey, ok = graph.journey(w.home, j.station); !ok {
+					continue
+				}
+				fromHome[w.home] = journey
+			}
+			candidates = append(candidates, w)
+			rides[w] = len(journey)
+		}
+		if len(candidates) < j.workers {
+			break
+		}
+		sort.Slice(candidates, func(i, k int) bool {
+			a, b := candidates[i], candidates[k]
+			if rides[a] != rides[b] {
+				return rides[a] < rides[b]
+			}
+			return a.id < b.id
+		})
+		j.arrivals = make(chan int)
+		j.absent = make(chan int)
+		j.finished = make(chan struct{})
+		graph.spawn(func() { graph.runJob(j) })
+		for _, w := range candidates[:j.workers] {
+			graph.emit(&WorkerAssigned{Worker: w.id, Task: j.id, Station: j.station.name})
+			graph.settle(func() { idle[w] <- j })
+			delete(idle, w)
+		}
+		queue = queue[1:]
+	}
+	return queue
+}
+
+/*
+runJob waits for all workers of j to arrive and performs the task. The task is dropped if any
+of them can't get to its station, once all the others arrived.
+*/
+func (graph *Graph) runJob(j *job) {
+	absent := 0
+	for reported := 0; reported < j.workers; reported++ {
+		select {
+		case <-j.arrivals:
+		case <-j.absent:
+			absent++
+		case <-graph.done:
+			return
+		}
+	}
+	if absent > 0 {
+		graph.emit(&Message{Source: "Jobs", Text: fmt.Sprintf("%d of %d workers can't get to %s, dropping task %d",
+			absent, j.workers, j.station.name, j.id)})
+		graph.dismiss(j, j.workers-absent)
+		return
+	}
+	graph.emit(&TaskStarted{Task: j.id, Station: j.station.name, Workers: j.workers})
+	graph.sleep(graph.duration(j.duration))
+	graph.emit(&TaskFinished{Task: j.id, Station: j.station.name})
+	graph.reportTask(true)
+	graph.dismiss(j, j.workers)
+}
+
+/*
+dismiss lets the given number of workers waiting at the task's station go, one at a time -
+so that they get back to the job board in the same order every run.
+*/
+func (graph *Graph) dismiss(j *job, workers int) {
+	for i := 0; i < workers; i++ {
+		stopped := false
+		graph.settle(func() {
+			select {
+			case j.finished <- struct{}{}:
+			case <-graph.done:
+				stopped = true
+			}
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// post hands a task created at station over to the job board, unless the simulation stops first
+func (b *jobBoard) post(t task, station *Station, graph *Graph) {
+	select {
+	case b.posted <- &job{task: t, station: station}:
+	case <-graph.done:
+	}
+}
+
+// next reports w idle at home and waits for its next job
+func (b *jobBoard) next(w *worker, graph *Graph) *job {
+	reply := make(chan *job, 1)
+	select {
+	case b.ready <- workerReady{w, reply}:
+	case <-graph.done:
+		graph.exit()
+	}
+	select {
+	case j := <-reply:
+		return j
+	case <-graph.done:
+		graph.exit()
+		return nil
+	}
+}
+
+// reportTask sends the outcome of a task to the stats handler: true once it's done, false when it's created
+func (graph *Graph) reportTask(done bool) {
+	select {
+	case graph.tasks <- done:
+	case <-graph.done:
+	}
+}
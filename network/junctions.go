@@ -3,13 +3,14 @@ package network
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 )
 
 // Junction is a network's vertex
 type Junction struct {
 	basePosition
-	ID       int
-	Tracks   map[int][]Track // target junction id -> tracks to that junction
+	ID       string
+	Tracks   map[string][]Track // target junction id -> tracks to that junction
 	WaitTime float64
 }
 
@@ -17,7 +18,7 @@ type Junction struct {
 Name - implements Location.Name()
 */
 func (j *Junction) Name() string {
-	return fmt.Sprintf("Junction #%d", j.ID)
+	return fmt.Sprintf("Junction #%s", j.ID)
 }
 
 /*
@@ -33,7 +34,7 @@ func (j *Junction) TravelTime(speed float64) float64 {
 // }
 
 func (j *Junction) String() string {
-	return fmt.Sprintf("Junction{id: %d, waitTime: %.2f}", j.ID, j.WaitTime)
+	return fmt.Sprintf("Junction{id: %s, waitTime: %.2f}", j.ID, j.WaitTime)
 }
 
 func (j *Junction) neighbours() []Location {
@@ -44,22 +45,81 @@ func (j *Junction) neighbours() []Location {
 	return neighbours
 }
 
+// allTracks returns the tracks leaving the junction, sorted by the junction they lead to and name
 func (j *Junction) allTracks() []Track {
+	targets := make([]string, 0, len(j.Tracks))
+	for target := range j.Tracks {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
 	list := make([]Track, 0)
-	for _, tracks := range j.Tracks {
-		for _, track := range tracks {
-			list = append(list, track)
-		}
+	for _, target := range targets {
+		tracks := append([]Track(nil), j.Tracks[target]...)
+		sort.Slice(tracks, func(a, b int) bool { return tracks[a].Name() < tracks[b].Name() })
+		list = append(list, tracks...)
 	}
 	return list
 }
 
-func junctionFromJSON(raw map[string]*json.RawMessage, config *graphConfig) *Junction {
+func junctionFromJSON(raw map[string]*json.RawMessage, config *graphConfig,
+	path string, errs *ValidationErrors) *Junction {
+
 	var junction Junction
-	junction.basePosition = basePosition{config, -1, make(chan request), make(chan request)}
-	json.Unmarshal(*raw["id"], &junction.ID)
-	json.Unmarshal(*raw["waitTime"], &junction.WaitTime)
+	junction.basePosition = basePosition{config, -1, make(chan request), make(chan request),
+		failureModelFromJSON(raw, path, errs)}
+	ok := decodeField(raw, "id", (*junctionID)(&junction.ID), path, errs)
+	ok = decodeField(raw, "waitTime", &junction.WaitTime, path, errs) && ok
+	if !ok {
+		return nil
+	}
+	if junction.WaitTime < 0 {
+		errs.add(fieldPath(path, "waitTime"), "must not be negative")
+	}
 	junction.WaitTime /= 60 // minutes in json -> hours
-	junction.Tracks = make(map[int][]Track)
+	junction.Tracks = make(map[string][]Track)
 	return &junction
 }
+
+/*
+junctionID is a junction identifier in JSON, which can be either a number or a string.
+Numbers are converted to their decimal representation.
+*/
+type junctionID string
+
+func (id *junctionID) UnmarshalJSON(raw []byte) error {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		if name == "" {
+			return fmt.Errorf("junction id must not be empty")
+		}
+		*id = junctionID(name)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return fmt.Errorf("junction id must be a number or a string, got %s", raw)
+	}
+	if _, err := number.Int64(); err != nil {
+		return fmt.Errorf("junction id must be an integer, got %s", number)
+	}
+	*id = junctionID(number.String())
+	return nil
+}
+
+/*
+decodeJunction reads a required field referencing a junction by its id.
+Returns nil and records an error if the field is missing or the junction does not exist.
+*/
+func decodeJunction(raw map[string]*json.RawMessage, name string, graph *Graph,
+	path string, errs *ValidationErrors) *Junction {
+
+	var id junctionID
+	if !decodeField(raw, name, &id, path, errs) {
+		return nil
+	}
+	junction := graph.Junction(string(id))
+	if junction == nil {
+		errs.add(fieldPath(path, name), "unknown junction %q", id)
+	}
+	return junction
+}
@@ -0,0 +1,65 @@
+package network
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJunctionIDs(t *testing.T) {
+	tests := []struct {
+		raw string
+		id  string // "" if it's invalid
+	}{
+		{`7`, "7"},
+		{`"7"`, "7"},
+		{`"north"`, "north"},
+		{`007`, ""}, // not valid JSON
+		{`7.5`, ""},
+		{`""`, ""},
+		{`true`, ""},
+	}
+	for _, test := range tests {
+		var id junctionID
+		err := json.Unmarshal([]byte(test.raw), &id)
+		if test.id == "" && err == nil {
+			t.Errorf("%s unmarshalled to %q, want an error", test.raw, id)
+		} else if test.id != "" && (err != nil || string(id) != test.id) {
+			t.Errorf("%s unmarshalled to %q, %v, want %q", test.raw, id, err, test.id)
+		}
+	}
+}
+
+func TestJunctionLookupByDeclaredID(t *testing.T) {
+	graph := parseGraph(t, `{
+		"config": {"clock": "virtual"},
+		"junctions": [{"id": 10, "waitTime": 6}, {"id": "north", "waitTime": 6}, {"id": 2, "waitTime": 6}],
+		"tracks": [
+			{"a": "10", "b": "north", "length": 10, "maxSpeed": 10, "id": "t", "type": "transit"},
+			{"a": 2, "b": "10", "waitTime": 6, "id": "w", "type": "wait"}
+		],
+		"stations": [{"a": 2, "b": 10, "name": "S"}],
+		"vehicles": []
+	}`)
+	for _, id := range []string{"10", "north", "2"} {
+		if junction := graph.Junction(id); junction == nil || junction.ID != id {
+			t.Errorf("Junction(%q) = %v", id, junction)
+		}
+	}
+	if junction := graph.Junction("1"); junction != nil {
+		t.Errorf("Junction(%q) = %v, want nil rather than the junction at that index", "1", junction)
+	}
+	if track := graph.trackNamed("t"); track.A().ID != "10" || track.B().ID != "north" {
+		t.Errorf("track t connects %s and %s, want 10 and north", track.A().Name(), track.B().Name())
+	}
+	if station := graph.Stations["S"]; station.A.ID != "2" || station.B.ID != "10" {
+		t.Errorf("station S is at %s and %s, want 2 and 10", station.A.Name(), station.B.Name())
+	}
+	var invalid Graph
+	err := json.Unmarshal([]byte(`{"config": {"clock": "virtual"}, "junctions": [{"id": 1, "waitTime": 6},
+		{"id": "1", "waitTime": 6}], "tracks": [{"a": 1, "b": 3, "length": 1, "maxSpeed": 1, "id": "t",
+		"type": "transit"}], "stations": [], "vehicles": []}`), &invalid)
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 2 || errs[0].Path != "junctions[1].id" || errs[1].Path != "tracks[0].b" {
+		t.Errorf("got %v, want a duplicate junction id and an unknown junction", err)
+	}
+}
@@ -0,0 +1,83 @@
+package network
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// kmh2PerMs2 converts an acceleration in m/s^2 to km/h^2
+const kmh2PerMs2 = 3600 * 3600 / 1000.0
+
+/*
+kinematicLocation is a Location whose travel time depends on how quickly
+a vehicle accelerates and brakes, not only on its top speed
+*/
+type kinematicLocation interface {
+	Location
+	// kinematicTravelTime returns time in hours required to get through the location,
+	// entering it from a neighbouring location (nil if unknown), accelerations are in km/h^2
+	kinematicTravelTime(from Location, speed float64, acceleration float64, braking float64) float64
+}
+
+/*
+kinematics describes how quickly a vehicle changes its speed.
+Vehicles without it reach any speed instantly.
+*/
+type kinematics struct {
+	Acceleration float64 // in m/s^2
+	Braking      float64 // in m/s^2
+}
+
+// travelTime returns time in hours the vehicle needs to get through loc, entering it from from (nil if unknown)
+func (v *baseVehicle) travelTime(loc Location, from Location) float64 {
+	if k, ok := loc.(kinematicLocation); ok && v.kinematics != nil {
+		return k.kinematicTravelTime(from, v.maxSpeed,
+			v.kinematics.Acceleration*kmh2PerMs2, v.kinematics.Braking*kmh2PerMs2)
+	}
+	return loc.TravelTime(v.maxSpeed)
+}
+
+/*
+kinematicTime returns time in hours needed to cover length km, entering at speed entry,
+accelerating towards cruise and braking in time to leave at speed exit. Speeds are in km/h,
+accelerations in km/h^2. If the distance is too short to reach cruise, the vehicle starts
+braking at a lower peak speed.
+*/
+func kinematicTime(length, entry, cruise, exit, acceleration, braking float64) float64 {
+	accelDistance := (cruise*cruise - entry*entry) / (2 * acceleration)
+	brakeDistance := (cruise*cruise - exit*exit) / (2 * braking)
+	if accelDistance+brakeDistance <= length {
+		return (cruise-entry)/acceleration + (cruise-exit)/braking +
+			(length-accelDistance-brakeDistance)/cruise
+	}
+	peak := math.Sqrt((2*acceleration*braking*length + braking*entry*entry + acceleration*exit*exit) /
+		(acceleration + braking))
+	if peak < math.Max(entry, exit) {
+		// can't even change from entry to exit speed on the way - assume a constant change
+		return 2 * length / (entry + exit)
+	}
+	return (peak-entry)/acceleration + (peak-exit)/braking
+}
+
+// kinematicsFromJSON decodes optional acceleration and braking of a vehicle
+func kinematicsFromJSON(raw map[string]*json.RawMessage, path string, errs *ValidationErrors) *kinematics {
+	var k kinematics
+	hasAcceleration := decodeOptionalField(raw, "acceleration", &k.Acceleration, path, errs)
+	hasBraking := decodeOptionalField(raw, "braking", &k.Braking, path, errs)
+	if !hasAcceleration && !hasBraking {
+		return nil
+	}
+	ok := true
+	if k.Acceleration <= 0 {
+		errs.add(fieldPath(path, "acceleration"), "must be positive, together with braking")
+		ok = false
+	}
+	if k.Braking <= 0 {
+		errs.add(fieldPath(path, "braking"), "must be positive, together with acceleration")
+		ok = false
+	}
+	if !ok {
+		return nil
+	}
+	return &k
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+EventLogger returns an event handler printing events to logger in human-readable form,
+prefixed with simulated time. If colour is set, messages from vehicles are
+coloured with ANSI escape codes.
+*/
+func EventLogger(logger *log.Logger, colour bool) func(Event) {
+	return func(event Event) {
+		text := describeEvent(event)
+		if msg, ok := event.(*Message); ok && colour && msg.Vehicle != 0 {
+			text = "\x1b[3" + strconv.Itoa(msg.Vehicle%9) + "m" + text + "\x1b[39m"
+		}
+		logger.Printf("[%s] %s", formatSimTime(event.Time()), text)
+	}
+}
+
+// formatSimTime formats simulated time as hours:minutes:seconds
+func formatSimTime(t time.Duration) string {
+	seconds := int64(t / time.Second)
+	return fmt.Sprintf("%4d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// formatDelay formats the difference between actual and scheduled time, always with a sign
+func formatDelay(d time.Duration) string {
+	if d < 0 {
+		return d.String()
+	}
+	return "+" + d.String()
+}
+
+func describeEvent(event Event) string {
+	switch e := event.(type) {
+	case *VehicleEntered:
+		return fmt.Sprintf("[%s] Vehicle #%d arrived", e.Location, e.Vehicle)
+	case *VehicleLeft:
+		return fmt.Sprintf("[%s] Vehicle #%d left", e.Location, e.Vehicle)
+	case *EntryDenied:
+		if e.Holder > 0 {
+			return fmt.Sprintf("[%s] Refusing entry to vehicle #%d - %s by vehicle #%d",
+				e.Location, e.Vehicle, e.Reason, e.Holder)
+		}
+		return fmt.Sprintf("[%s] Refusing entry to vehicle #%d - %s", e.Location, e.Vehicle, e.Reason)
+	case *ReservationMade:
+		return fmt.Sprintf("[%s] Reserved for vehicle #%d", e.Location, e.Vehicle)
+	case *ReservationReleased:
+		return fmt.Sprintf("[%s] Reservation of vehicle #%d released", e.Location, e.Vehicle)
+	case *FailureRaised:
+		if e.Target != e.Location {
+			return fmt.Sprintf("[%s] Failure at %s: %s, severity %d", e.Target, e.Location, e.Kind, e.Severity)
+		}
+		return fmt.Sprintf("[%s] Failure: %s, severity %d", e.Target, e.Kind, e.Severity)
+	case *RepairAssigned:
+		return fmt.Sprintf("[%s] Assigned to repair vehicle #%d", e.Target, e.Crew)
+	case *RepairStarted:
+		return fmt.Sprintf("[%s] Repair started by vehicle #%d", e.Target, e.Crew)
+	case *RepairFinished:
+		return fmt.Sprintf("[%s] Repaired by vehicle #%d, back online", e.Target, e.Crew)
+	case *TaskCreated:
+		return fmt.Sprintf("[Station %s] New task #%d: %d workers, %.2fh", e.Station, e.Task, e.Workers, e.Duration)
+	case *WorkerAssigned:
+		return fmt.Sprintf("[Station %s] Worker #%d assigned to task #%d", e.Station, e.Worker, e.Task)
+	case *WorkerBoarded:
+		return fmt.Sprintf("[Station %s] Worker #%d boarded train #%d", e.Station, e.Worker, e.Train)
+	case *WorkerAlighted:
+		return fmt.Sprintf("[Station %s] Worker #%d got off train #%d", e.Station, e.Worker, e.Train)
+	case *TrainLoaded:
+		text := fmt.Sprintf("[Train #%d] Leaving %s with %d/%d passengers", e.Train, e.Station, e.Passengers, e.Capacity)
+		if e.Refused > 0 {
+			text += fmt.Sprintf(", %d left behind", e.Refused)
+		}
+		return text
+	case *JourneyCompleted:
+		return fmt.Sprintf("[Station %s] Passenger from %s arrived after %v: %d rides, waited %v, lost %v",
+			e.To, e.From, e.Duration, e.Rides, e.Waiting, e.Lost)
+	case *CargoLoaded:
+		return fmt.Sprintf("[Train #%d] Loaded cargo at %s", e.Train, e.Station)
+	case *CargoDelivered:
+		return fmt.Sprintf("[Train #%d] Delivered cargo to %s", e.Train, e.Station)
+	case *FreightYielded:
+		passing := make([]string, len(e.Passing))
+		for i, train := range e.Passing {
+			passing[i] = fmt.Sprintf("#%d", train)
+		}
+		return fmt.Sprintf("[Train #%d] Waiting on %s until %s, letting %s pass", e.Train, e.Location,
+			formatSimTime(e.Until), strings.Join(passing, ", "))
+	case *TaskStarted:
+		return fmt.Sprintf("[Station %s] Task #%d started, %d workers arrived", e.Station, e.Task, e.Workers)
+	case *TaskFinished:
+		return fmt.Sprintf("[Station %s] Task #%d finished", e.Station, e.Task)
+	case *StopServed:
+		return fmt.Sprintf("[Train #%d] Served station %s: arrival %s (%s), departure %s (%s)",
+			e.Train, e.Station, formatSimTime(e.Arrival), formatDelay(e.Arrival-e.ScheduledArrival),
+			formatSimTime(e.Departure), formatDelay(e.Departure-e.ScheduledDeparture))
+	case *Rerouted:
+		return fmt.Sprintf("[Train #%d] Rerouted to %s around %s, detour: %+.2fh",
+			e.Train, e.Station, strings.Join(e.Avoiding, ", "), e.Detour)
+	case *LocationClosed:
+		return fmt.Sprintf("[%s] Closed for maintenance until %s", e.Location, formatSimTime(e.Until))
+	case *LocationReopened:
+		return fmt.Sprintf("[%s] Reopened after maintenance", e.Location)
+	case *TrainDelayed:
+		return fmt.Sprintf("[Train #%d] Held at %s for %v", e.Train, e.Location, e.Delay)
+	case *MaintenanceAssigned:
+		return fmt.Sprintf("[%s] Maintenance assigned to repair vehicle #%d", e.Location, e.Crew)
+	case *MaintenanceStarted:
+		return fmt.Sprintf("[%s] Maintenance started by vehicle #%d", e.Location, e.Crew)
+	case *MaintenanceFinished:
+		return fmt.Sprintf("[%s] Maintenance finished by vehicle #%d", e.Location, e.Crew)
+	case *MaintenanceMissed:
+		if e.Crew == 0 {
+			return fmt.Sprintf("[%s] Maintenance missed, no crew made it in time", e.Location)
+		}
+		return fmt.Sprintf("[%s] Maintenance missed by vehicle #%d", e.Location, e.Crew)
+	case *DeadlockDetected:
+		cycle := make([]string, len(e.Vehicles))
+		for i, vehicle := range e.Vehicles {
+			cycle[i] = fmt.Sprintf("#%d (at %s)", vehicle, e.Locations[i])
+		}
+		text := "[Deadlock] Vehicles waiting for each other: " + strings.Join(cycle, " -> ")
+		if e.Victim != 0 {
+			text += fmt.Sprintf(", backing off #%d", e.Victim)
+		}
+		return text
+	case *Message:
+		return fmt.Sprintf("[%s] %s", e.Source, e.Text)
+	}
+	return fmt.Sprintf("%T%+v", event, event)
+}
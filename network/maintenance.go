@@ -0,0 +1,196 @@
+package network
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+/*
+possession is planned maintenance of a track or junction, repeated periodically
+unless every is 0. During each window the location is closed to trains, while a repair
+crew, assigned in advance, maintains it - which makes it less likely to fail afterwards.
+*/
+type possession struct {
+	location  Location
+	from      time.Duration // start of the first window
+	until     time.Duration // end of the first window
+	every     time.Duration // 0 for a single window
+	lead      time.Duration // how long before a window starts a crew is assigned to it, see maintenanceLead
+	reduction float64       // of the location's failure rates, see failureModel.maintained
+}
+
+// maintenanceWindow is a single window of a possession
+type maintenanceWindow struct {
+	*possession
+	start time.Duration
+	end   time.Duration
+}
+
+func (graph *Graph) loadMaintenance(rawPossessions []map[string]*json.RawMessage, errs *ValidationErrors) {
+	for i, rawPossession := range rawPossessions {
+		if p := possessionFromJSON(rawPossession, graph, indexPath("maintenance", i), errs); p != nil {
+			graph.possessions = append(graph.possessions, p)
+		}
+	}
+}
+
+func possessionFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) *possession {
+
+	var p possession
+	location, targets, ok := decodeLocation(raw, graph, path, errs, true)
+	if targets != 1 {
+		errs.add(path, "expected exactly one of track or junction")
+		return nil
+	}
+	p.location = location
+	var from, until simTime
+	ok = decodeField(raw, "from", &from, path, errs) && ok
+	ok = decodeField(raw, "until", &until, path, errs) && ok
+	if !ok {
+		return nil
+	}
+	if from < 0 {
+		errs.add(fieldPath(path, "from"), "must not be negative")
+		ok = false
+	}
+	if until <= from {
+		errs.add(fieldPath(path, "until"), "must be later than from")
+		ok = false
+	}
+	var every float64
+	decodeOptionalField(raw, "every", &every, path, errs)
+	if every != 0 && every < float64(until-from) {
+		errs.add(fieldPath(path, "every"), "windows must not overlap: must be at least %.2f hours",
+			float64(until-from))
+		ok = false
+	}
+	lead := graph.maintenanceLead(location)
+	if decodeOptionalField(raw, "lead", &lead, path, errs) && lead < 0 {
+		errs.add(fieldPath(path, "lead"), "must not be negative")
+		ok = false
+	}
+	p.reduction = 0.5
+	decodeOptionalField(raw, "reduction", &p.reduction, path, errs)
+	if p.reduction < 0 || p.reduction > 1 {
+		errs.add(fieldPath(path, "reduction"), "must be between 0 and 1")
+		ok = false
+	}
+	if !ok {
+		return nil
+	}
+	p.from = graph.duration(float64(from))
+	p.until = graph.duration(float64(until))
+	p.every = graph.duration(every)
+	p.lead = graph.duration(lead)
+	return &p
+}
+
+/*
+maintenanceLead returns the default time in hours, before a window starts, to assign a crew
+to location: enough for any of the crews to get there from its base, plus an hour to spare
+*/
+func (graph *Graph) maintenanceLead(location Location) float64 {
+	lead := 0.0
+	for _, vehicle := range graph.Vehicles {
+		if rv, ok := vehicle.(*RepairVehicle); ok {
+			if _, travelTime, reachable := graph.shortestPath(rv.Base, location, rv.maxSpeed, nil); reachable {
+				lead = math.Max(lead, travelTime)
+			}
+		}
+	}
+	return lead + 1
+}
+
+/*
+runPossession sends crews to the possession's windows and closes the location during them,
+until the simulation is stopped
+*/
+func (graph *Graph) runPossession(p *possession) {
+	for start := p.from; ; start += p.every {
+		window := &maintenanceWindow{p, start, start + p.until - p.from}
+		if wait := window.start - p.lead - graph.Clock.Now(); wait > 0 {
+			graph.sleep(wait)
+		}
+		select {
+		case graph.dispatcher.planned <- window:
+		case <-graph.done:
+			return
+		}
+		if wait := window.start - graph.Clock.Now(); wait > 0 {
+			graph.sleep(wait)
+		}
+		graph.closeFor(p.location, window.end, "Maintenance")
+		graph.sleep(window.end - graph.Clock.Now())
+		if p.every == 0 {
+			return
+		}
+	}
+}
+
+/*
+setOff keeps rv where it is until it's time to leave for window's location, so that it gets there
+as the window opens - rather than keep trains out of it while waiting there, or on the way
+*/
+func (rv *RepairVehicle) setOff(window *maintenanceWindow, from Location, context *Graph) {
+	_, travelTime, _ := context.shortestPath(from, window.location, rv.maxSpeed, nil)
+	if wait := window.start - context.duration(travelTime) - context.Clock.Now(); wait > 0 {
+		rv.logf("[Maintenance] Waiting to set off for %s", window.location.Name())
+		context.sleep(wait)
+	}
+}
+
+// maintain performs maintenance during window, after rv arrived at its location
+func (rv *RepairVehicle) maintain(window *maintenanceWindow, context *Graph) {
+	name := window.location.Name()
+	if context.Clock.Now() >= window.end {
+		rv.logf("[Maintenance] Arrived at %s too late", name)
+		context.missMaintenance(rv.id, name)
+		return
+	}
+	if wait := window.start - context.Clock.Now(); wait > 0 {
+		rv.logf("[Maintenance] Waiting for the window at %s", name)
+		context.sleep(wait)
+	}
+	context.emit(&MaintenanceStarted{Crew: rv.id, Location: name})
+	context.sleep(window.end - context.Clock.Now())
+	if !rv.send(window.location, request{kind: maintain, reduction: window.reduction}) {
+		context.missMaintenance(rv.id, name)
+		return
+	}
+	context.emit(&MaintenanceFinished{Crew: rv.id, Location: name})
+	context.reportMaintenance(true)
+}
+
+// missMaintenance records that the maintenance of location didn't happen
+func (graph *Graph) missMaintenance(crew int, location string) {
+	graph.emit(&MaintenanceMissed{Crew: crew, Location: location})
+	graph.reportMaintenance(false)
+}
+
+// reportMaintenance sends the outcome of a maintenance window to the stats handler
+func (graph *Graph) reportMaintenance(done bool) {
+	select {
+	case graph.maintenance <- done:
+	case <-graph.done:
+	}
+}
+
+/*
+closeFor closes location to trains until the given simulated time. Closures may overlap,
+e.g. a scenario closing a track under maintenance - it reopens once the last of them ends.
+*/
+func (graph *Graph) closeFor(location Location, until time.Duration, source string) {
+	if graph.send(location, request{kind: closeDown}) {
+		graph.emit(&LocationClosed{Location: location.Name(), Until: until})
+	} else {
+		graph.emit(&Message{Source: source, Text: location.Name() + " is already closed"})
+	}
+	graph.spawn(func() {
+		graph.sleep(until - graph.Clock.Now())
+		if graph.send(location, request{kind: reopen}) {
+			graph.emit(&LocationReopened{Location: location.Name()})
+		}
+	})
+}
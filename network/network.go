@@ -1,17 +1,20 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
-	"log"
 	"math/rand"
+	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
 
 type trackKeyType struct {
-	A, B int
+	A, B string
 }
 
 type report struct {
@@ -23,20 +26,53 @@ type report struct {
 type Graph struct {
 	Config        *graphConfig
 	Junctions     []*Junction
+	junctionIndex map[string]*Junction
 	tracks        map[trackKeyType][]Track
 	Stations      map[string]*Station
-	StationLookup map[int]*Station
+	StationLookup map[string]*Station
 	Vehicles      []Vehicle
-	Emergency     chan emergency
+	Clock         Clock
+	Events        *EventBus
+	dispatcher    *dispatcher
+	jobs          *jobBoard
+	workers       []*worker
+	demand        []*demand
 	emergencyCtr  chan report
+	servedStops   chan *StopServed
+	loads         chan *TrainLoaded
+	journeys      chan *JourneyCompleted
+	scenario      []*scenarioEvent // sorted by time
+	possessions   []*possession
+	maintenance   chan bool // outcomes of maintenance windows, true if done
+	tasks         chan bool // false for created tasks, true for finished ones
+	waits         chan waitUpdate
+	done          <-chan struct{}
+	running       sync.WaitGroup
+	// estimated stops of trains, for planning journeys
+	trainSchedules []*schedule
+	schedulesOnce  sync.Once
 }
 
 // graphConfig stores general configuration settings of the simulated network
 type graphConfig struct {
 	TimeScale   float64 // number of milliseconds per simulated hour
-	RepairTime  float64 // in hours
+	Clock       string  // "real" (default) or "virtual"
+	Seed        int64   // seed of all random number streams; 0 picks one based on current time
+	Duration    float64 // simulated hours to run for; 0 runs until cancelled
+	Rerouting   string  // what trains do when their way is failing: "wait" (default), "always" or "shorter"
+	Deadlocks   string  // what to do about vehicles waiting for each other: "report" (default) or "backoff"
+	Vehicles    vehicleSelection
+	RepairTime  float64 // in hours, for failures not covered by Failures
+	Failures    []*failureType
 	FailureRate float64 // probability of a network element failure per hour
-	Tasks       taskConfig
+	// in seconds, how long a train stays longer at a stop for each passenger getting on or off
+	BoardingTime float64
+	// 24 hourly multipliers of passenger demand rates, constant by default
+	DemandProfile []float64
+	// failure models of junctions, tracks and trains; constant FailureRate by default
+	FailureModels map[string]*failureModelConfig
+	failureModels map[string]failureModel
+	Tasks         taskConfig
 }
 
 type requestHandler interface {
@@ -45,34 +81,137 @@ type requestHandler interface {
 }
 
 /*
-Start beging the simulation
+Start runs the simulation until ctx is cancelled or the configured duration
+of simulated time passes. All goroutines of the simulation are stopped before it returns.
 */
-func (graph *Graph) Start() {
-	rand.Seed(time.Now().UnixNano())
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go graph.statsHandler()
+func (graph *Graph) Start(ctx context.Context) *Summary {
+	started := time.Now()
+	if graph.Clock == nil {
+		graph.Clock = graph.Config.newClock()
+		if stopper, ok := graph.Clock.(interface{ Stop() }); ok {
+			defer stopper.Stop()
+		}
+	}
+	if graph.Config.Seed == 0 {
+		graph.Config.Seed = time.Now().UnixNano()
+	}
+	graph.logf("Random seed: %d", graph.Config.Seed)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	graph.done = ctx.Done()
+	graph.emergencyCtr = make(chan report)
+	graph.servedStops = make(chan *StopServed)
+	graph.loads = make(chan *TrainLoaded)
+	graph.journeys = make(chan *JourneyCompleted)
+	graph.maintenance = make(chan bool)
+	graph.tasks = make(chan bool)
+	graph.waits = make(chan waitUpdate)
+	stats := make(chan *Summary, 1)
+	go graph.statsHandler(stats)
+	graph.dispatcher = newDispatcher()
+	go graph.dispatcher.run(graph)
+	go graph.detectDeadlocks()
+	graph.jobs = newJobBoard()
+	go graph.jobs.run(graph)
+	if graph.Config.Duration > 0 {
+		go func() {
+			select {
+			case <-graph.Clock.After(graph.duration(graph.Config.Duration)):
+				graph.logf("Simulated %v, stopping", graph.duration(graph.Config.Duration))
+				cancel()
+			case <-graph.done:
+			}
+		}()
+	}
 
 	for _, junction := range graph.Junctions {
-		wg.Add(1)
-		go Handle(junction, graph)
+		junction := junction
+		graph.spawn(func() { Handle(junction, graph) })
 	}
 
 	for _, track := range graph.Tracks() {
-		wg.Add(1)
-		go Handle(track, graph)
+		track := track
+		graph.spawn(func() { Handle(track, graph) })
+	}
+	for _, vehicle := range graph.Vehicles {
+		if !graph.Config.Vehicles.includes(vehicle) {
+			graph.logf("Skipping vehicle #%d (%s)", vehicle.ID(), vehicleType(vehicle))
+			continue
+		}
+		vehicle := vehicle
+		graph.spawn(func() { vehicle.Handle(graph) })
 	}
-	// for i := 0; i < len(graph.Vehicles); i++ {
-	// 	wg.Add(1)
-	// 	go graph.Vehicles[i].Handle(graph)
-	// }
-	graph.Vehicles[5].Handle(graph)
 
-	for _, station := range graph.Stations {
-		wg.Add(1)
-		go station.Handle(graph)
+	for _, name := range graph.stationNames() {
+		station := graph.Stations[name]
+		graph.spawn(func() { station.Handle(graph) })
+	}
+	for _, w := range graph.workers {
+		w := w
+		graph.spawn(func() { w.run(graph) })
+	}
+	for i, d := range graph.demand {
+		d, rng := d, graph.random(fmt.Sprintf("demand:%d", i))
+		graph.spawn(func() { graph.generatePassengers(d, rng) })
+	}
+	if len(graph.scenario) > 0 {
+		graph.spawn(graph.runScenario)
+	}
+	for _, p := range graph.possessions {
+		p := p
+		graph.spawn(func() { graph.runPossession(p) })
+	}
+	<-graph.done
+	graph.running.Wait()
+
+	summary := <-stats
+	summary.Seed = graph.Config.Seed
+	summary.SimulatedTime = graph.Clock.Now()
+	summary.WallTime = time.Since(started)
+	graph.logf("Simulation stopped\n%v", summary)
+	graph.Events.Flush()
+	return summary
+}
+
+// logf emits a diagnostic Message about the whole network
+func (graph *Graph) logf(format string, args ...interface{}) {
+	graph.emit(&Message{Source: "Network", Text: fmt.Sprintf(format, args...)})
+}
+
+/*
+spawn runs f in a new goroutine, which the simulation waits for when stopping.
+Such goroutines may exit early through runtime.Goexit (see Graph.sleep).
+With the virtual clock, f runs on its own until it blocks, see Graph.settle.
+*/
+func (graph *Graph) spawn(f func()) {
+	graph.running.Add(1)
+	graph.settle(func() {
+		go func() {
+			defer graph.running.Done()
+			f()
+		}()
+	})
+}
+
+/*
+settle calls wake, which makes other goroutines runnable, and lets them run until they block before it goes on.
+With the virtual clock, goroutines of the simulation then take turns rather than run at once,
+which makes same-seed runs the same. With the real clock, it only calls wake.
+*/
+func (graph *Graph) settle(wake func()) {
+	if clock, ok := graph.Clock.(*virtualClock); ok {
+		clock.settle(wake)
+	} else {
+		wake()
 	}
-	wg.Wait()
+}
+
+/*
+exit terminates the calling goroutine, which must have been started with
+Graph.spawn. Used to unwind vehicles' logic when the simulation stops.
+*/
+func (graph *Graph) exit() {
+	runtime.Goexit()
 }
 
 /*
@@ -83,7 +222,14 @@ func (graph *Graph) StationWith(j *Junction) Station {
 }
 
 /*
-Tracks provide a slice of all unique tracks in the graph
+Junction returns the junction with given id, or nil if there's none
+*/
+func (graph *Graph) Junction(id string) *Junction {
+	return graph.junctionIndex[id]
+}
+
+/*
+Tracks provide a slice of all unique tracks in the graph, sorted by name
 */
 func (graph *Graph) Tracks() []Track {
 	visited := make(map[trackKeyType]bool)
@@ -97,68 +243,243 @@ func (graph *Graph) Tracks() []Track {
 		}
 		visited[k] = true
 	}
+	sort.Slice(uniqueTracks, func(i, j int) bool {
+		return uniqueTracks[i].Name() < uniqueTracks[j].Name()
+	})
 	return uniqueTracks
 }
 
-func (graph *Graph) scaledTime(hours float64) time.Duration {
-	return time.Millisecond * time.Duration(graph.Config.TimeScale*hours)
+// stationNames returns the names of all stations in the graph, sorted
+func (graph *Graph) stationNames() []string {
+	names := make([]string, 0, len(graph.Stations))
+	for name := range graph.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func (graph *Graph) waitTime() time.Duration {
-	return graph.scaledTime((float64(rand.Intn(30)) + 10.0) / 60)
+// policies of rerouting trains around failures
+const (
+	rerouteWait    = "wait"    // wait until the failure is repaired
+	rerouteAlways  = "always"  // take a detour whenever there's one
+	rerouteShorter = "shorter" // take a detour only if it's shorter than the repair time
+)
+
+// rerouting checks whether trains should look for detours around failures
+func (config *graphConfig) rerouting() bool {
+	return config.Rerouting == rerouteAlways || config.Rerouting == rerouteShorter
 }
 
-func (graph *Graph) repairTime() time.Duration {
-	return graph.scaledTime(graph.Config.RepairTime)
+// newClock creates the Clock selected in the configuration
+func (config *graphConfig) newClock() Clock {
+	if config.Clock == "virtual" {
+		return NewVirtualClock()
+	}
+	return NewRealClock(config.TimeScale)
 }
 
-// generateFailures randomly treis to generate a failure every hour, until it succeeds
-func (graph *Graph) generateFailures(accident chan<- bool) {
-	ticker := time.NewTicker(graph.scaledTime(1.0))
-	<-time.After(graph.scaledTime(2.0))
-	for {
-		<-ticker.C
-		if rand.Float64() < graph.Config.FailureRate {
-			accident <- true
-			return
-		}
+// duration converts a number of simulated hours to a Duration
+func (graph *Graph) duration(hours float64) time.Duration {
+	return time.Duration(hours * float64(time.Hour))
+}
+
+/*
+sleep blocks for d of simulated time.
+If the simulation stops in the meantime, the calling goroutine exits instead
+*/
+func (graph *Graph) sleep(d time.Duration) {
+	select {
+	case <-graph.Clock.After(d):
+	case <-graph.done:
+		graph.exit()
 	}
 }
 
-func (graph *Graph) generateTasks(tasks chan<- task, stop <-chan bool) {
-	ticker := time.NewTicker(graph.scaledTime(1.0))
-	<-time.After(graph.scaledTime(2.0))
-	for {
+// report sends r to the stats handler, unless the simulation stops first
+func (graph *Graph) report(r report) {
+	select {
+	case graph.emergencyCtr <- r:
+	case <-graph.done:
+	}
+}
+
+// serveStop publishes a stop of a timetabled train and counts it in the summary
+func (graph *Graph) serveStop(stop *StopServed) {
+	graph.emit(stop)
+	select {
+	case graph.servedStops <- stop:
+	case <-graph.done:
+	}
+}
+
+// reportLoad publishes the load of a train leaving a station and counts it in the summary
+func (graph *Graph) reportLoad(load *TrainLoaded) {
+	graph.emit(load)
+	select {
+	case graph.loads <- load:
+	case <-graph.done:
+	}
+}
+
+// raiseEmergency hands e over to the dispatcher of repair crews, unless the simulation stops first
+func (graph *Graph) raiseEmergency(e emergency) {
+	select {
+	case graph.dispatcher.emergencies <- e:
+	case <-graph.done:
+	}
+}
+
+/*
+random creates an independent stream of random numbers for the entity identified by key,
+derived from the simulation's seed. Each goroutine should use its own stream.
+*/
+func (graph *Graph) random(key string) *rand.Rand {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return rand.New(rand.NewSource(graph.Config.Seed ^ int64(hash.Sum64())))
+}
+
+func (graph *Graph) waitTime(rng *rand.Rand) time.Duration {
+	return graph.duration((float64(rng.Intn(30)) + 10.0) / 60)
+}
+
+// longestWait is the longest time waitTime returns
+func (graph *Graph) longestWait() time.Duration {
+	return graph.duration(39.0 / 60)
+}
+
+func (graph *Graph) repairTime(failure *failureType) time.Duration {
+	return graph.duration(failure.RepairTime)
+}
 
-		<-ticker.C
-		if rand.Float64() < graph.Config.Tasks.Rate {
-			tasks <- graph.Config.Tasks.randomTask()
+/*
+generateFailures draws the next failure from model, if there's one, and reports it
+in the background when it happens. rng is only used by the calling goroutine.
+*/
+func (graph *Graph) generateFailures(accident chan<- bool, model failureModel, rng *rand.Rand) {
+	wait, fails := model.untilFailure(graph.Clock.Now(), rng)
+	if !fails {
+		return
+	}
+	graph.spawn(func() {
+		graph.sleep(wait)
+		select {
+		case accident <- true:
+		case <-graph.done:
 		}
+	})
+}
 
+func (graph *Graph) generateTasks(tasks chan<- task, rng *rand.Rand) {
+	graph.sleep(graph.duration(2.0))
+	for {
+		graph.sleep(graph.duration(1.0))
+		if rng.Float64() < graph.Config.Tasks.Rate {
+			select {
+			case tasks <- graph.Config.Tasks.randomTask(rng):
+			case <-graph.done:
+				return
+			}
+		}
 	}
 }
 
-func (graph *Graph) statsHandler() {
-	graph.emergencyCtr = make(chan report)
-	status := make(map[string]struct{})
+func (graph *Graph) statsHandler(result chan<- *Summary) {
+	summary := &Summary{}
+	status := make(map[string]time.Duration) // failed element -> time of the failure
+	var repairTime time.Duration
+	stops := make(map[[2]int]*StopDelays) // [train, stop] -> delays
+	loads := make(map[int]*TrainLoads)
 	activeEmergencies := 0
 	for {
-		report := <-graph.emergencyCtr
+		var report report
+		select {
+		case report = <-graph.emergencyCtr:
+		case stop := <-graph.servedStops:
+			key := [2]int{stop.Train, stop.Stop}
+			if stops[key] == nil {
+				stops[key] = &StopDelays{Train: stop.Train, Stop: stop.Stop, Station: stop.Station}
+				summary.Stops = append(summary.Stops, stops[key])
+			}
+			stops[key].add(stop)
+			continue
+		case load := <-graph.loads:
+			if loads[load.Train] == nil {
+				loads[load.Train] = &TrainLoads{Train: load.Train, Capacity: load.Capacity}
+			}
+			loads[load.Train].add(load)
+			continue
+		case journey := <-graph.journeys:
+			summary.Journeys++
+			summary.JourneyTime += journey.Duration
+			summary.WaitingTime += journey.Waiting
+			summary.LostTime += journey.Lost
+			continue
+		case done := <-graph.maintenance:
+			if done {
+				summary.Maintenance++
+			} else {
+				summary.MissedMaintenance++
+			}
+			continue
+		case done := <-graph.tasks:
+			if done {
+				summary.TasksDone++
+			} else {
+				summary.TasksCreated++
+			}
+			continue
+		case <-graph.done:
+			for k := range status {
+				summary.ActiveEmergencies = append(summary.ActiveEmergencies, k)
+			}
+			sort.Strings(summary.ActiveEmergencies)
+			for _, load := range loads {
+				load.finish(graph.Clock.Now())
+				if load.MaxPassengers > 0 || load.Refused > 0 {
+					summary.Loads = append(summary.Loads, load)
+				}
+			}
+			sort.Slice(summary.Loads, func(i, j int) bool { return summary.Loads[i].Train < summary.Loads[j].Train })
+			sort.Slice(summary.Stops, func(i, j int) bool {
+				if summary.Stops[i].Train != summary.Stops[j].Train {
+					return summary.Stops[i].Train < summary.Stops[j].Train
+				}
+				return summary.Stops[i].Stop < summary.Stops[j].Stop
+			})
+			result <- summary
+			return
+		}
 		activeEmergencies += report.delta
 		if report.delta > 0 {
-			status[report.key] = struct{}{}
+			summary.Failures++
+			status[report.key] = graph.Clock.Now()
 		} else if report.delta < 0 {
+			summary.Repairs++
+			if failed, ok := status[report.key]; ok {
+				repairTime += graph.Clock.Now() - failed
+				summary.MeanTimeToRepair = repairTime / time.Duration(summary.Repairs)
+			}
 			delete(status, report.key)
 		}
-		emergencies := ""
+		active := make([]string, 0, len(status))
 		for k := range status {
+			active = append(active, k)
+		}
+		sort.Strings(active)
+		emergencies := ""
+		for _, k := range active {
 			emergencies += fmt.Sprintf("%19s %s\n", "", k)
 		}
-		log.Printf("[Network] %d active emergencies: \n%s", activeEmergencies, emergencies)
+		graph.logf("%d active emergencies: \n%s", activeEmergencies, emergencies)
 	}
 }
 
-// LoadGraph loads a Graph description from a JSON file
+/*
+LoadGraph loads a Graph description from a JSON file.
+If the description is invalid, the returned error is a ValidationErrors listing every problem found.
+*/
 func LoadGraph(filename string) (*Graph, error) {
 	raw, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -172,42 +493,118 @@ func LoadGraph(filename string) (*Graph, error) {
 }
 
 func (graph *Graph) UnmarshalJSON(s []byte) error {
-
 	var raw map[string]json.RawMessage
-	json.Unmarshal(s, &raw)
-	if err := json.Unmarshal(raw["config"], &graph.Config); err != nil {
-		log.Panicln("Unable to unmarshal config data: ", err)
+	if err := json.Unmarshal(s, &raw); err != nil {
+		return err
 	}
-	graph.Emergency = make(chan emergency)
-	graph.StationLookup = make(map[int]*Station)
+	errs := ValidationErrors{}
+	graph.loadConfig(raw["config"], &errs)
+	graph.Events = &EventBus{}
+	graph.StationLookup = make(map[string]*Station)
 
-	graph.loadJunctions(raw["junctions"])
-	graph.loadTracks(raw["tracks"])
-	graph.loadStations(raw["stations"])
-	graph.loadVehicles(raw["vehicles"])
+	graph.loadJunctions(decodeList(raw, "junctions", &errs), &errs)
+	graph.loadTracks(decodeList(raw, "tracks", &errs), &errs)
+	graph.loadStations(decodeList(raw, "stations", &errs), &errs)
+	if raw["workers"] != nil {
+		graph.loadWorkers(decodeList(raw, "workers", &errs), &errs)
+	}
+	graph.loadVehicles(decodeList(raw, "vehicles", &errs), &errs)
+	graph.Config.Vehicles.validate(graph.Vehicles, "config.vehicles", &errs)
+	if raw["scenario"] != nil {
+		graph.loadScenario(decodeList(raw, "scenario", &errs), &errs)
+	}
+	if raw["maintenance"] != nil {
+		graph.loadMaintenance(decodeList(raw, "maintenance", &errs), &errs)
+	}
+	if raw["demand"] != nil {
+		graph.loadDemand(decodeList(raw, "demand", &errs), &errs)
+	}
 
-	return nil
+	return errs.err()
 }
 
-func (graph *Graph) loadJunctions(raw json.RawMessage) {
-	var rawJunctions []map[string]*json.RawMessage
-	if err := json.Unmarshal(raw, &rawJunctions); err != nil {
-		log.Panicln("Unable to unmarshal junction data: ", err)
+func (graph *Graph) loadConfig(raw json.RawMessage, errs *ValidationErrors) {
+	graph.Config = &graphConfig{}
+	if raw == nil {
+		errs.add("config", "missing required section")
+		return
 	}
-	for _, rawJunction := range rawJunctions {
-		graph.Junctions = append(graph.Junctions, junctionFromJSON(rawJunction, graph.Config))
-
+	if err := json.Unmarshal(raw, graph.Config); err != nil {
+		errs.add("config", "%v", err)
+		return
+	}
+	if graph.Config.TimeScale < 0 {
+		errs.add("config.timeScale", "must not be negative")
+	} else if graph.Config.TimeScale == 0 && graph.Config.Clock != "virtual" {
+		errs.add("config.timeScale", "must be positive with the real clock")
+	}
+	if graph.Config.RepairTime < 0 {
+		errs.add("config.repairTime", "must not be negative")
+	}
+	if graph.Config.Duration < 0 {
+		errs.add("config.duration", "must not be negative")
+	}
+	if graph.Config.BoardingTime < 0 {
+		errs.add("config.boardingTime", "must not be negative")
+	}
+	if graph.Config.FailureRate < 0 || graph.Config.FailureRate > 1 {
+		errs.add("config.failureRate", "must be a probability between 0 and 1")
+	}
+	if graph.Config.Clock != "" && graph.Config.Clock != "real" && graph.Config.Clock != "virtual" {
+		errs.add("config.clock", "unknown clock: %q", graph.Config.Clock)
+	}
+	if graph.Config.DemandProfile != nil {
+		validateProfile(graph.Config.DemandProfile, "config.demandProfile", errs)
+	}
+	graph.Config.validateFailures(errs)
+	graph.Config.buildFailureModels(errs)
+	switch graph.Config.Rerouting {
+	case "", rerouteWait, rerouteAlways, rerouteShorter:
+	default:
+		errs.add("config.rerouting", "unknown policy: %q (expected %q, %q or %q)",
+			graph.Config.Rerouting, rerouteWait, rerouteAlways, rerouteShorter)
+	}
+	switch graph.Config.Deadlocks {
+	case "", deadlocksReport, deadlocksBackoff:
+	default:
+		errs.add("config.deadlocks", "unknown policy: %q (expected %q or %q)",
+			graph.Config.Deadlocks, deadlocksReport, deadlocksBackoff)
 	}
 }
 
-func (graph *Graph) loadTracks(raw json.RawMessage) {
-	var rawTracks []map[string]*json.RawMessage
-	if err := json.Unmarshal(raw, &rawTracks); err != nil {
-		log.Panicln("Unable to unmarshal track data: ", err)
+func (graph *Graph) loadJunctions(rawJunctions []map[string]*json.RawMessage, errs *ValidationErrors) {
+	graph.junctionIndex = make(map[string]*Junction)
+	seen := make(map[string]string)
+	for i, rawJunction := range rawJunctions {
+		path := indexPath("junctions", i)
+		junction := junctionFromJSON(rawJunction, graph.Config, path, errs)
+		if junction == nil {
+			continue
+		}
+		if other, ok := seen[junction.ID]; ok {
+			errs.add(fieldPath(path, "id"), "duplicate junction id %q (already used by %s)", junction.ID, other)
+			continue
+		}
+		seen[junction.ID] = path
+		graph.Junctions = append(graph.Junctions, junction)
+		graph.junctionIndex[junction.ID] = junction
 	}
+}
+
+func (graph *Graph) loadTracks(rawTracks []map[string]*json.RawMessage, errs *ValidationErrors) {
 	graph.tracks = make(map[trackKeyType][]Track)
-	for _, rawTrack := range rawTracks {
-		track := trackFromJSON(rawTrack, graph.Junctions, graph.Config)
+	seen := make(map[string]string)
+	for i, rawTrack := range rawTracks {
+		path := indexPath("tracks", i)
+		track := trackFromJSON(rawTrack, graph, path, errs)
+		if track == nil {
+			continue
+		}
+		if other, ok := seen[track.id()]; ok {
+			errs.add(fieldPath(path, "id"), "duplicate track id %q (already used by %s)", track.id(), other)
+			continue
+		}
+		seen[track.id()] = path
 		a := track.A().ID
 		b := track.B().ID
 		track.A().Tracks[b] = append(track.A().Tracks[b], track)
@@ -217,26 +614,44 @@ func (graph *Graph) loadTracks(raw json.RawMessage) {
 	}
 }
 
-func (graph *Graph) loadStations(raw json.RawMessage) {
-	var rawStations []map[string]*json.RawMessage
-	if err := json.Unmarshal(raw, &rawStations); err != nil {
-		log.Panicln("Unable to unmarshal station data: ", err)
-	}
+func (graph *Graph) loadStations(rawStations []map[string]*json.RawMessage, errs *ValidationErrors) {
 	graph.Stations = make(map[string]*Station)
-	for _, rawStation := range rawStations {
-		station := stationFromJSON(rawStation, graph.Junctions)
+	seen := make(map[string]string)
+	for i, rawStation := range rawStations {
+		path := indexPath("stations", i)
+		station := stationFromJSON(rawStation, graph, path, errs)
+		if station == nil {
+			continue
+		}
+		if other, ok := seen[station.name]; ok {
+			errs.add(fieldPath(path, "name"), "duplicate station name %q (already used by %s)", station.name, other)
+			continue
+		}
+		for _, junction := range []*Junction{station.A, station.B} {
+			if other, ok := graph.StationLookup[junction.ID]; ok {
+				errs.add(path, "%s already belongs to station %s", junction.Name(), other.name)
+			}
+		}
+		seen[station.name] = path
 		graph.Stations[station.name] = station
 		graph.StationLookup[station.A.ID] = station
 		graph.StationLookup[station.B.ID] = station
 	}
 }
 
-func (graph *Graph) loadVehicles(raw json.RawMessage) {
-	var rawVehicles []map[string]*json.RawMessage
-	if err := json.Unmarshal(raw, &rawVehicles); err != nil {
-		log.Panicln("Unable to unmarshal vehicle data: ", err)
-	}
-	for _, rawVehicle := range rawVehicles {
-		graph.Vehicles = append(graph.Vehicles, vehicleFromJSON(rawVehicle, graph))
+func (graph *Graph) loadVehicles(rawVehicles []map[string]*json.RawMessage, errs *ValidationErrors) {
+	seen := make(map[int]string)
+	for i, rawVehicle := range rawVehicles {
+		path := indexPath("vehicles", i)
+		vehicle := vehicleFromJSON(rawVehicle, graph, path, errs)
+		if vehicle == nil {
+			continue
+		}
+		if other, ok := seen[vehicle.ID()]; ok {
+			errs.add(fieldPath(path, "id"), "duplicate vehicle id %d (already used by %s)", vehicle.ID(), other)
+			continue
+		}
+		seen[vehicle.ID()] = path
+		graph.Vehicles = append(graph.Vehicles, vehicle)
 	}
 }
@@ -0,0 +1,169 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wallTime matches the wall-clock time in the summary logged at the end of a run
+var wallTime = regexp.MustCompile(`wall: [^,]*, `)
+
+/*
+runEvents simulates the network described in file with the virtual clock and returns its event log.
+Events published at the same simulated time come from goroutines running at once, so their order
+isn't fixed - they're sorted to compare only what happened and when.
+*/
+func runEvents(t *testing.T, file string, seed int64, hours float64) []string {
+	t.Helper()
+	graph, err := LoadGraph(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.Config.Clock = "virtual"
+	graph.Config.Seed = seed
+	graph.Config.Duration = hours
+	var log bytes.Buffer
+	writer := NewEventWriter(&log)
+	unsubscribe := graph.Events.Subscribe(writer.Write)
+	graph.Start(context.Background())
+	unsubscribe()
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(wallTime.ReplaceAllString(log.String(), "")), "\n")
+	times := make([]float64, len(lines))
+	for i, line := range lines {
+		var event struct{ Time float64 }
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("event %d: %v", i+1, err)
+		}
+		times[i] = event.Time
+	}
+	sort.Stable(byTime{lines, times})
+	return lines
+}
+
+// parseGraph loads a network description given inline, failing the test if it's invalid
+func parseGraph(t *testing.T, description string) *Graph {
+	t.Helper()
+	graph := &Graph{}
+	if err := json.Unmarshal([]byte(description), graph); err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+// byTime sorts events by their simulated time and then by their text
+type byTime struct {
+	lines []string
+	times []float64
+}
+
+func (b byTime) Len() int { return len(b.lines) }
+
+func (b byTime) Less(i, j int) bool {
+	if b.times[i] != b.times[j] {
+		return b.times[i] < b.times[j]
+	}
+	return b.lines[i] < b.lines[j]
+}
+
+func (b byTime) Swap(i, j int) {
+	b.lines[i], b.lines[j] = b.lines[j], b.lines[i]
+	b.times[i], b.times[j] = b.times[j], b.times[i]
+}
+
+// the runs have to be the same whatever the scheduling, e.g. with go test -race -cpu 1,4,8
+func TestSameSeedSameEvents(t *testing.T) {
+	first := runEvents(t, "../network.json", 7, 24)
+	second := runEvents(t, "../network.json", 7, 24)
+	for i := 0; i < len(first) && i < len(second); i++ {
+		if first[i] != second[i] {
+			t.Fatalf("runs differ at event %d:\n%s\n%s", i+1, first[i], second[i])
+		}
+	}
+	if len(first) != len(second) {
+		t.Fatalf("runs published %d and %d events", len(first), len(second))
+	}
+}
+
+func TestLoadConfigReportsValidationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		paths  []string // of the reported errors, in order
+	}{
+		{"valid", `{"timeScale": 1000, "failureRate": 0.01}`, nil},
+		{"virtual clock without time scale", `{"clock": "virtual"}`, nil},
+		{"missing", ``, []string{"config"}},
+		{"malformed", `{"timeScale": "fast"}`, []string{"config"}},
+		{"real clock without time scale", `{}`, []string{"config.timeScale"}},
+		{"negative values", `{"timeScale": -1, "repairTime": -1, "duration": -1, "boardingTime": -1}`,
+			[]string{"config.timeScale", "config.repairTime", "config.duration", "config.boardingTime"}},
+		{"failure rate above 1", `{"timeScale": 1000, "failureRate": 1.5}`, []string{"config.failureRate"}},
+		{"unknown clock", `{"timeScale": 1000, "clock": "atomic"}`, []string{"config.clock"}},
+		{"unknown policies", `{"clock": "virtual", "rerouting": "never", "deadlocks": "ignore"}`,
+			[]string{"config.rerouting", "config.deadlocks"}},
+		{"failure types", `{"clock": "virtual", "failures": [{"elements": ["bridge"], "severity": 0, "repairTime": 1},
+			{"name": "signal", "elements": ["junction"], "severity": 1, "repairTime": 0}]}`,
+			[]string{"config.failures[0].name", "config.failures[0].elements[0]", "config.failures[0].severity",
+				"config.failures[1].repairTime"}},
+		{"failure model", `{"clock": "virtual", "failureModels": {"track": {"model": "weibull", "shape": 0, "scale": 10}}}`,
+			[]string{"config.failureModels.track.shape"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var raw json.RawMessage
+			if test.config != "" {
+				raw = json.RawMessage(test.config)
+			}
+			errs := ValidationErrors{}
+			(&Graph{}).loadConfig(raw, &errs)
+			var paths []string
+			for _, err := range errs {
+				paths = append(paths, err.Path)
+			}
+			if strings.Join(paths, ", ") != strings.Join(test.paths, ", ") {
+				t.Errorf("errors at %v, want %v:\n%v", paths, test.paths, errs)
+			}
+		})
+	}
+}
+
+func TestStartStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		cancel   bool // once the scenario breaks the track at 3:00
+		want     time.Duration
+	}{
+		{"after the duration", 2, false, 2 * time.Hour},
+		{"when cancelled", 0, true, 3 * time.Hour},
+		{"when cancelled before the duration", 12, true, 3 * time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			graph := parseGraph(t, shuttleNetwork)
+			graph.Config.Duration = test.duration
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// the subscriber cancels it from its own goroutine, before the virtual clock moves on
+			unsubscribe := graph.Events.Subscribe(func(event Event) {
+				if _, ok := event.(*FailureRaised); ok && test.cancel {
+					cancel()
+				}
+			})
+			summary := graph.Start(ctx)
+			unsubscribe()
+			if summary.SimulatedTime != test.want {
+				t.Errorf("stopped after %v, want %v", summary.SimulatedTime, test.want)
+			}
+		})
+	}
+}
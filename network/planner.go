@@ -0,0 +1,205 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// criteria of planning journeys, see Graph.PlanJourney
+const (
+	EarliestArrival = "earliest"
+	FewestTransfers = "transfers"
+)
+
+// Itinerary is a way of travelling between two stations by train
+type Itinerary struct {
+	From      string
+	To        string
+	Departure time.Duration // from the first station
+	Arrival   time.Duration // to the last one
+	Legs      []Leg
+}
+
+// Leg is a part of an Itinerary ridden on a single train
+type Leg struct {
+	Train     int
+	From      string
+	To        string
+	Departure time.Duration
+	Arrival   time.Duration
+}
+
+// Transfers is the number of times the Itinerary changes trains
+func (it *Itinerary) Transfers() int {
+	return len(it.Legs) - 1
+}
+
+func (it *Itinerary) String() string {
+	clock := func(t time.Duration) string { return strings.TrimSpace(formatSimTime(t)) }
+	text := fmt.Sprintf("%s -> %s: departure %s, arrival %s, transfers: %d",
+		it.From, it.To, clock(it.Departure), clock(it.Arrival), it.Transfers())
+	for _, leg := range it.Legs {
+		text += fmt.Sprintf("\n  Train #%d: %s %s -> %s %s", leg.Train,
+			leg.From, clock(leg.Departure), leg.To, clock(leg.Arrival))
+	}
+	return text
+}
+
+/*
+schedule estimates when a train stops at the stations of its route: as in its timetable if it has one,
+otherwise running non-stop from the first station at the start, as fast as it can
+*/
+type schedule struct {
+	train      *Train
+	period     time.Duration
+	arrivals   []time.Duration // at each stop of the route, in the first lap
+	departures []time.Duration
+}
+
+func (s *schedule) arrival(lap int, stop int) time.Duration {
+	return time.Duration(lap)*s.period + s.arrivals[stop]
+}
+
+func (s *schedule) departure(lap int, stop int) time.Duration {
+	return time.Duration(lap)*s.period + s.departures[stop]
+}
+
+// nextDeparture returns the first lap in which the train leaves stop no earlier than at
+func (s *schedule) nextDeparture(stop int, at time.Duration) int {
+	if at <= s.departures[stop] {
+		return 0
+	}
+	return int(math.Ceil(float64(at-s.departures[stop]) / float64(s.period)))
+}
+
+func (graph *Graph) scheduleOf(t *Train) *schedule {
+	s := &schedule{train: t}
+	if t.Timetable != nil {
+		s.period = graph.duration(t.Timetable.Period)
+		for i := range t.Route {
+			s.arrivals = append(s.arrivals, t.Timetable.arrival(graph, 0, i))
+			s.departures = append(s.departures, t.Timetable.departure(graph, 0, i))
+		}
+		return s
+	}
+	for i, station := range t.Route {
+		s.arrivals = append(s.arrivals, s.period)
+		s.departures = append(s.departures, s.period)
+		next := t.Route[t.nextStationIdx(i)]
+		path, _, _ := graph.shortestPath(station.A, next.A, t.maxSpeed, nil)
+		var from Location = station.A
+		for _, loc := range append(path, t.waitTracksAt(next)[0]) {
+			s.period += graph.duration(t.travelTime(loc, from))
+			from = loc
+		}
+	}
+	return s
+}
+
+// schedules returns the schedules of the running trains, sorted by train id
+func (graph *Graph) schedules() []*schedule {
+	graph.schedulesOnce.Do(func() {
+		for _, vehicle := range graph.Vehicles {
+			if train, ok := vehicle.(*Train); ok && train.cargo == nil && graph.Config.Vehicles.includes(train) {
+				graph.trainSchedules = append(graph.trainSchedules, graph.scheduleOf(train))
+			}
+		}
+		sort.Slice(graph.trainSchedules, func(i, j int) bool {
+			return graph.trainSchedules[i].train.id < graph.trainSchedules[j].train.id
+		})
+	})
+	return graph.trainSchedules
+}
+
+/*
+PlanJourney finds an itinerary by train from one station to another, leaving no earlier than at.
+It arrives as early as possible (EarliestArrival), or changes trains as few times as possible
+and then arrives as early as possible (FewestTransfers). Times follow the trains' timetables,
+or are estimated for trains without one.
+*/
+func (graph *Graph) PlanJourney(from string, to string, at time.Duration, by string) (*Itinerary, error) {
+	source, target := graph.Stations[from], graph.Stations[to]
+	if source == nil {
+		return nil, fmt.Errorf("unknown station %q", from)
+	}
+	if target == nil {
+		return nil, fmt.Errorf("unknown station %q", to)
+	}
+	if source == target {
+		return nil, fmt.Errorf("%s is both the origin and the destination", from)
+	}
+	if by != EarliestArrival && by != FewestTransfers {
+		return nil, fmt.Errorf("unknown criterion %q (expected %q or %q)", by, EarliestArrival, FewestTransfers)
+	}
+	found := graph.itineraries(source, target, at)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no trains from %s to %s", from, to)
+	}
+	if by == FewestTransfers {
+		return found[0], nil
+	}
+	return found[len(found)-1], nil
+}
+
+// label is the earliest known arrival at a station, using a given number of trains
+type label struct {
+	arrival  time.Duration
+	leg      *Leg
+	previous *label // at the station the leg starts from, nil at the origin
+}
+
+/*
+itineraries finds the itineraries from one station to another, leaving no earlier than at,
+that arrive the earliest for a given number of trains, in rounds - each allowing one more train.
+Each of them arrives earlier than the previous one, so the first one has the fewest transfers
+and the last one arrives the earliest.
+*/
+func (graph *Graph) itineraries(from *Station, to *Station, at time.Duration) []*Itinerary {
+	reached := map[*Station]*label{from: {arrival: at}}
+	best := map[*Station]time.Duration{from: at}
+	var found []*Itinerary
+	for len(reached) > 0 {
+		next := make(map[*Station]*label)
+		for _, s := range graph.schedules() {
+			route := s.train.Route
+			for i, station := range route {
+				boarding, ok := reached[station]
+				if !ok {
+					continue
+				}
+				lap := s.nextDeparture(i, boarding.arrival)
+				for step := 1; step < len(route); step++ {
+					stop := (i + step) % len(route)
+					arrival := s.arrival(lap+(i+step)/len(route), stop)
+					if earliest, ok := best[route[stop]]; ok && earliest <= arrival {
+						continue
+					}
+					best[route[stop]] = arrival
+					next[route[stop]] = &label{
+						arrival:  arrival,
+						leg:      &Leg{s.train.id, station.name, route[stop].name, s.departure(lap, i), arrival},
+						previous: boarding,
+					}
+				}
+			}
+		}
+		if arrival, ok := next[to]; ok {
+			found = append(found, itineraryTo(arrival, from, to))
+		}
+		reached = next
+	}
+	return found
+}
+
+// itineraryTo follows the labels back to the origin
+func itineraryTo(arrival *label, from *Station, to *Station) *Itinerary {
+	it := &Itinerary{From: from.name, To: to.name, Arrival: arrival.arrival}
+	for l := arrival; l.leg != nil; l = l.previous {
+		it.Legs = append([]Leg{*l.leg}, it.Legs...)
+	}
+	it.Departure = it.Legs[0].Departure
+	return it
+}
@@ -1,5 +1,7 @@
 package network
 
+import "time"
+
 func doFree(s *handlerStatus, req request) bool {
 	if s.failing {
 		s.logf("Cannot release vehicle #%d", req.senderID)
@@ -7,7 +9,8 @@ func doFree(s *handlerStatus, req request) bool {
 	}
 	if s.occupant == req.senderID {
 		s.occupant = -1
-		s.logf("Vehicle #%d left", req.senderID)
+		s.emit(&VehicleLeft{Vehicle: req.senderID, Location: s.position.Name()})
+		s.left(req.senderID)
 		return true
 	}
 	s.logf("Vehicle #%d wants to leave but occupant is #%d", req.senderID, s.occupant)
@@ -15,42 +18,79 @@ func doFree(s *handlerStatus, req request) bool {
 
 }
 
+/*
+doTake lets a vehicle in, if the position is free. Vehicles yielding to others, i.e. freight trains,
+aren't let in while any other vehicle denied entry is still retrying (see Graph.longestWait),
+so that it's the one to get in once the position is free.
+*/
 func doTake(s *handlerStatus, req request) bool {
 	if s.failing && s.reservation != req.senderID {
-		s.logf("refusing entry to vehicle #%d", req.senderID)
+		s.emit(&EntryDenied{Vehicle: req.senderID, Location: s.position.Name(), Reason: "failing"})
+		s.waitsFor(req.senderID, 0)
+		return false
+	}
+	if s.closures > 0 && s.reservation != req.senderID {
+		s.emit(&EntryDenied{Vehicle: req.senderID, Location: s.position.Name(), Reason: "closed"})
+		s.waitsFor(req.senderID, 0)
 		return false
 	}
 	if s.occupant == -1 || s.occupant == req.senderID {
 		if s.reservation > 0 && s.reservation != req.senderID {
-			s.logf("Refusing entry to vehicle #%d - location reserved by Vehicle#%d", req.senderID, s.reservation)
+			s.emit(&EntryDenied{Vehicle: req.senderID, Location: s.position.Name(),
+				Reason: "reserved", Holder: s.reservation})
+			s.waitsFor(req.senderID, s.reservation)
+			s.wait(req)
+			return false
+		}
+		if waiting := s.waitingVehicle(); req.yielding && s.occupant != req.senderID && waiting > 0 {
+			s.emit(&EntryDenied{Vehicle: req.senderID, Location: s.position.Name(),
+				Reason: "priority", Holder: waiting})
+			s.waitsFor(req.senderID, 0) // only until the other one retries, not a deadlock
 			return false
 		}
-		s.logf("Vehicle #%d arrived", req.senderID)
+		s.emit(&VehicleEntered{Vehicle: req.senderID, Location: s.position.Name()})
 		s.occupant = req.senderID
+		delete(s.waiting, req.senderID)
+		s.waitsFor(req.senderID, 0)
 		return true
 
 	}
-	s.logf("Refusing entry to vehicle #%d - location already occupied by Vehicle#%d", req.senderID, s.occupant)
+	s.emit(&EntryDenied{Vehicle: req.senderID, Location: s.position.Name(),
+		Reason: "occupied", Holder: s.occupant})
+	s.waitsFor(req.senderID, s.occupant)
+	s.wait(req)
 	return false
 }
 
 func doRelease(s *handlerStatus, req request) bool {
 	if s.reservation == req.senderID {
-		s.logf("Releasing reservation")
+		s.emit(&ReservationReleased{Vehicle: req.senderID, Location: s.position.Name()})
 		s.reservation = -1
+		s.left(req.senderID)
 		return true
 	}
 	s.logf("Rejecting 'release' from vehicle #%d - reserved by vehicle #%d", req.senderID, s.reservation)
 	return false
 }
 
+/*
+doReserve reserves the position for a vehicle, if it isn't reserved by another one or occupied.
+A failing position may be reserved while occupied - its occupant can't leave until it's repaired.
+*/
 func doReserve(s *handlerStatus, req request) bool {
-	if s.reservation == -1 {
-		s.logf("Reserving for %d", req.senderID)
+	if s.occupant != -1 && s.occupant != req.senderID && !s.failing {
+		s.logf("Rejecting reservation by vehicle #%d - occupied by vehicle #%d", req.senderID, s.occupant)
+		s.waitsFor(req.senderID, s.occupant)
+		return false
+	}
+	if s.reservation == -1 || s.reservation == req.senderID {
+		s.emit(&ReservationMade{Vehicle: req.senderID, Location: s.position.Name()})
 		s.reservation = req.senderID
+		s.waitsFor(req.senderID, 0)
 		return true
 	}
 	s.logf("Rejecting reservation by vehicle #%d - already reserved by vehicle #%d", req.senderID, s.reservation)
+	s.waitsFor(req.senderID, s.reservation)
 	return false
 }
 
@@ -61,6 +101,7 @@ func doRepairStart(s *handlerStatus, req request) bool {
 	}
 	s.logf("Repair started")
 	s.repairStarted = true
+	s.repairedSince = s.graph.Clock.Now()
 	return true
 }
 
@@ -75,3 +116,65 @@ func doRepairDone(s *handlerStatus, req request) bool {
 	s.logf("Ignoring repairDone: repairStart is required first")
 	return false
 }
+
+func doFail(s *handlerStatus, req request) bool {
+	if s.failing {
+		s.logf("Already failing")
+		return false
+	}
+	s.raise(req.failure)
+	return true
+}
+
+// doCloseDown adds a closure, each one needs its own reopen. Returns false if the position was already closed.
+func doCloseDown(s *handlerStatus, req request) bool {
+	s.closures++
+	if s.closures > 1 {
+		s.logf("Already closed")
+		return false
+	}
+	return true
+}
+
+// doReopen ends a closure. Returns true if the position is open again, with no other closures left.
+func doReopen(s *handlerStatus, req request) bool {
+	if s.closures == 0 {
+		s.logf("Ignoring reopen: not closed")
+		return false
+	}
+	s.closures--
+	if s.closures > 0 {
+		s.logf("Still closed")
+		return false
+	}
+	return true
+}
+
+/*
+doEstimateRepair sends the time the position's repair still takes, 0 unless it's failing.
+Until a crew starts repairing it, that's the whole repair time, not counting the crew's travel.
+Returns whether the position is failing.
+*/
+func doEstimateRepair(s *handlerStatus, req request) bool {
+	var left time.Duration
+	if s.failing {
+		left = s.graph.repairTime(s.failure)
+		if s.repairStarted {
+			left -= s.graph.Clock.Now() - s.repairedSince
+		}
+	}
+	if left < 0 {
+		left = 0
+	}
+	req.estimate <- left
+	return s.failing
+}
+
+func doMaintain(s *handlerStatus, req request) bool {
+	if s.failing {
+		s.logf("Cannot maintain while failing")
+		return false
+	}
+	s.logf("Maintained")
+	return true
+}
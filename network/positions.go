@@ -2,7 +2,7 @@ package network
 
 import (
 	"fmt"
-	"log"
+	"time"
 	// "sync"
 )
 
@@ -18,10 +18,15 @@ type Location interface {
 }
 
 type basePosition struct {
-	config    *graphConfig
-	occupant  int // occupying vehicle's id
-	request   chan request
-	emergency chan request
+	config       *graphConfig
+	occupant     int // occupying vehicle's id
+	request      chan request
+	emergency    chan request
+	failureModel failureModel // nil to use the one configured for the kind of element
+}
+
+func (pos *basePosition) ownFailureModel() failureModel {
+	return pos.failureModel
 }
 
 func (pos *basePosition) getRequestChannel() chan<- request {
@@ -43,29 +48,45 @@ const (
 	repairStart
 	repairDone
 	check
+	fail
+	closeDown
+	reopen
+	maintain
+	available
+	estimateRepair
 )
 
 //go:generate stringer -type requestType
 
 type request struct {
-	c        chan bool
-	senderID int
-	kind     requestType
+	c         chan bool
+	senderID  int
+	kind      requestType
+	failure   *failureType         // for fail requests
+	reduction float64              // for maintain requests, see failureModel.maintained
+	estimate  chan<- time.Duration // for estimateRepair requests, buffered
+	yielding  bool                 // for take requests of vehicles letting others in first, see doTake
 }
 
 type emergency struct {
 	location Location
 	handler  requestHandler
+	failure  *failureType
 }
 
 type handlerStatus struct {
 	occupant      int
 	position      Location
 	failing       bool
+	closures      int // overlapping closures, e.g. planned maintenance - unlike failing it needs no repair
 	reservation   int
+	failure       *failureType // the current one, if failing
 	repairStarted bool
+	repairedSince time.Duration         // when the current repair started
+	waiting       map[int]time.Duration // vehicles denied entry (except yielding ones) -> when last, see doTake
 	ctr           int
 	handlers      map[requestType]func(*handlerStatus, request) bool
+	graph         *Graph
 }
 
 var defaultHandlers = map[requestType]func(*handlerStatus, request) bool{
@@ -78,18 +99,84 @@ var defaultHandlers = map[requestType]func(*handlerStatus, request) bool{
 	check: func(s *handlerStatus, req request) bool {
 		return !s.failing
 	},
+	available: func(s *handlerStatus, req request) bool {
+		return !s.failing && s.closures == 0
+	},
+	fail:      doFail,
+	closeDown: doCloseDown,
+	reopen:    doReopen,
+	maintain:  doMaintain,
+
+	estimateRepair: doEstimateRepair,
 }
 
 func (s handlerStatus) logf(format string, args ...interface{}) {
 	tagFail := ""
 	if s.failing {
 		tagFail = " [Failing]"
+	} else if s.closures > 0 {
+		tagFail = " [Closed]"
+	}
+	s.emit(&Message{
+		Source: fmt.Sprintf("%s:%d%s", s.position.Name(), s.ctr, tagFail),
+		Text:   fmt.Sprintf(format, args...),
+	})
+}
+
+func (s handlerStatus) emit(event Event) {
+	s.graph.emit(event)
+}
+
+// waitsFor tells the deadlock detector that vehicle waits for holder here, or doesn't wait if holder is 0
+func (s *handlerStatus) waitsFor(vehicle int, holder int) {
+	if vehicle != holder {
+		s.graph.updateWaits(waitUpdate{vehicle: vehicle, holder: holder, location: s.position.Name()})
+	}
+}
+
+// left tells the deadlock detector that vehicle doesn't hold this position anymore
+func (s *handlerStatus) left(vehicle int) {
+	s.graph.updateWaits(waitUpdate{vehicle: vehicle, location: s.position.Name(), leaving: true})
+}
+
+// wait records that the sender of a denied take request waits to get in, unless it yields to others
+func (s *handlerStatus) wait(req request) {
+	if !req.yielding {
+		s.waiting[req.senderID] = s.graph.Clock.Now()
 	}
-	prefix := fmt.Sprintf("[%s:%d]%s ", s.position.Name(), s.ctr, tagFail)
-	log.Printf(prefix+format, args...)
 }
 
-// Handle handles position's communication with other network elements
+/*
+waitingVehicle returns the lowest id of the vehicles waiting to get in, or 0 if there are none.
+Vehicles not retrying within the longest wait since they were denied aren't waiting anymore.
+*/
+func (s *handlerStatus) waitingVehicle() int {
+	first := 0
+	for vehicle, denied := range s.waiting {
+		if s.graph.Clock.Now()-denied > s.graph.longestWait() {
+			delete(s.waiting, vehicle)
+		} else if first == 0 || vehicle < first {
+			first = vehicle
+		}
+	}
+	return first
+}
+
+// raise marks the position as failing and reports the emergency
+func (s *handlerStatus) raise(failure *failureType) {
+	s.failing = true
+	s.failure = failure
+	s.emit(&FailureRaised{Target: s.position.Name(), Location: s.position.Name(),
+		Kind: failure.Name, Severity: failure.Severity})
+	position, graph := s.position, s.graph
+	graph.spawn(func() { graph.report(report{delta: 1, key: position.Name()}) })
+	graph.spawn(func() { graph.raiseEmergency(emergency{position, position, failure}) })
+}
+
+/*
+Handle handles position's communication with other network elements,
+until the simulation is stopped
+*/
 func Handle(position Location, context *Graph) {
 	var req request
 	var response bool
@@ -100,28 +187,45 @@ func Handle(position Location, context *Graph) {
 		failing:       false,
 		reservation:   -1,
 		repairStarted: false,
+		waiting:       make(map[int]time.Duration),
 		ctr:           0,
 		handlers:      defaultHandlers,
+		graph:         context,
 	}
 	failures := make(chan bool)
 	requests := position.getRWRequestChannel()
-	go context.generateFailures(failures)
+	rng := context.random("failures:" + position.Name())
+	base := context.failureModelOf(position)
+	model := base
+	context.generateFailures(failures, model, rng)
+	generating := true
 	for {
 		select {
+		case <-context.done:
+			return
 		case req = <-requests:
 			s.ctr++
 			s.logf("request: %v", req)
 			response = s.handlers[req.kind](s, req)
-			if req.kind == repairDone && response {
+			if req.kind == maintain && response {
+				// abandon the pending failure, it's drawn from the old model
+				model = base.maintained(context.Clock.Now(), req.reduction)
+				failures = make(chan bool)
+				generating = false
+			}
+			if (req.kind == repairDone || req.kind == maintain) && response && !generating {
 				// restart failure generator
-				go context.generateFailures(failures)
+				context.generateFailures(failures, model, rng)
+				generating = true
 			}
 			req.c <- response
 		case <-failures:
-			s.failing = true
-			s.logf("Sending emergency report")
-			go func() { context.emergencyCtr <- report{delta: 1, key: position.Name()} }()
-			go func() { context.Emergency <- emergency{position, position} }()
+			generating = false
+			if s.failing {
+				// failed on request in the meantime, the generator restarts after the repair
+				continue
+			}
+			s.raise(context.Config.randomFailure(elementKind(position), rng))
 		}
 
 	}
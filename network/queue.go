@@ -16,7 +16,11 @@ type priorityQueue []*item
 
 func (pq priorityQueue) Len() int { return len(pq) }
 
+// Less orders items by travel time, and equally distant ones by name so that paths don't depend on map order
 func (pq priorityQueue) Less(i, j int) bool {
+	if pq[i].travelTime == pq[j].travelTime {
+		return pq[i].position.Name() < pq[j].position.Name()
+	}
 	return pq[i].travelTime < pq[j].travelTime
 }
 
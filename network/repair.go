@@ -1,12 +1,14 @@
 package network
 
 import (
-	"container/heap"
 	"encoding/json"
 	"fmt"
-	"time"
+	"sort"
 )
 
+// crewPatience is the number of failed attempts to reach an emergency, after which the crew gives it up
+const crewPatience = 5
+
 type RepairVehicle struct {
 	baseVehicle
 	Base *WaitTrack
@@ -16,112 +18,204 @@ func (rv *RepairVehicle) String() string {
 	return fmt.Sprintf("Repair{maxSpeed: %f, base: %s}", rv.maxSpeed, rv.Base.Name())
 }
 
-func (rv *RepairVehicle) travelTo(location Location, from Location, reserve bool, ctx *Graph) Location {
+/*
+travelTo moves rv from from to location, finding another path whenever the current one is blocked.
+With reserve, the path is reserved beforehand - apart from location itself unless enter is set,
+e.g. when it's occupied by a broken train, which rv only needs to get next to.
+After patience failed attempts (unless it's 0), gives up and reports false, along with
+the location reached.
+*/
+func (rv *RepairVehicle) travelTo(location Location, from Location, reserve bool, enter bool, patience int,
+	ctx *Graph) (Location, bool) {
+
 	success := false
 	var start = from
 	var blocked Location
 	var blacklist = []Location{}
-	for !success {
+	for attempt := 1; !success; attempt++ {
+		if patience > 0 && attempt > patience {
+			rv.logf("[Repair] Unable to reach %s, giving up", location.Name())
+			return start, false
+		}
 		rv.logf("[Repair] Calculating shortest path to %s", location.Name())
-		path := rv.findPath(start, location, ctx, blacklist)
+		path, reachable := rv.findPath(start, location, ctx, blacklist)
+		if !reachable {
+			delay := ctx.waitTime(rv.rng)
+			rv.logf("[Repair] No path to %s, retrying after %v", location.Name(), delay)
+			ctx.sleep(delay)
+			blacklist = []Location{}
+			continue
+		}
 		if len(path) == 0 {
 			rv.logf("[Repair] Already close enough for repairs")
 			success = true
 			continue
 		}
+		reserved := path
+		if !enter {
+			reserved = path[:len(path)-1]
+		}
 		if reserve {
-			rv.reserve(path)
+			if refused, ok := rv.reserve(reserved); !ok {
+				delay := ctx.waitTime(rv.rng)
+				rv.logf("[Repair] Unable to reserve the path, retrying after %v", delay)
+				ctx.sleep(delay)
+				blacklist = []Location{refused}
+				continue
+			}
 		}
-		rv.logf("[Repair] Path reserved")
 
-		start, blocked, success = rv.travelByPath(path, ctx)
+		start, blocked, success = rv.travelByPath(path, start, ctx)
 		if !success {
 			rv.logf("[Repair] Path blocked, retrying from %s", start.Name())
-			rv.release(path)
+			if reserve {
+				rv.release(remainingPath(reserved, start))
+			}
 			blacklist = []Location{blocked}
 		} else {
 			blacklist = []Location{}
 		}
 	}
-	return start
+	return start, true
 }
 
+/*
+Handle takes emergencies and planned maintenance from the dispatcher and handles them,
+returning to base whenever there's nothing more to do
+*/
 func (rv *RepairVehicle) Handle(context *Graph) {
-	var accidents = make(chan emergency, 10)
-	go func(queue chan emergency) {
-		for {
-			report := <-context.Emergency
-			rv.logf("[Repair] Received emergency report from %s", report.location.Name())
-			queue <- report
-		}
-	}(accidents)
-	rv.moveTo(rv.Base, nil, context)
+	rv.setUp(context)
+	for !rv.moveTo(rv.Base, nil, context) {
+	}
 	rv.logf("Arrived at base (%s)", rv.Base.Name())
+	var position Location = rv.Base
+	atBase := true
 	for {
-		accident := <-accidents
-		if accident.location == rv.Base {
-			rv.repair(accident.location, context)
+		accident := context.dispatcher.next(rv, position, atBase, context)
+		if accident == nil {
+			rv.logf("[Repair] Nothing to do, returning to base")
+			position, _ = rv.travelTo(rv.Base, position, false, true, 0, context)
+			atBase = true
+			continue
+		}
+		if accident.window != nil {
+			rv.logf("[Maintenance] Sent to %s", accident.location.Name())
+		} else {
+			rv.logf("[Repair] Received emergency report from %s", accident.location.Name())
+		}
+		atBase = false
+		if accident.location != position {
+			if accident.window != nil {
+				rv.setOff(accident.window, position, context)
+			}
+			_, broken := accident.handler.(*Train)
+			var reached bool
+			position, reached = rv.travelTo(accident.location, position, true, !broken, crewPatience, context)
+			if !reached {
+				context.dispatcher.abandon(rv, accident, context)
+				continue
+			}
+			rv.logf("[Repair] Arrived at target location %s", accident.location.Name())
+		}
+		if accident.window != nil {
+			rv.maintain(accident.window, context)
 			continue
 		}
-		var repairLocation = rv.travelTo(accident.location, rv.Base, true, context)
-		rv.logf("[Repair] Arrived at target location %s, beginning repair", accident.location.Name())
-		rv.repair(accident.handler, context)
-		rv.travelTo(rv.Base, repairLocation, false, context)
+		rv.repair(accident.handler, accident.failure, context)
 		rv.logf("[Repair] Repair done")
 	}
 }
 
-func (rv *RepairVehicle) travelByPath(path []Location, context *Graph) (Location, Location, bool) {
-	var lastLoc = path[0]
+/*
+travelByPath moves rv along the path, starting at from.
+Returns the location reached, the location that blocked the way (if any) and whether the travel was successful.
+If only the last location can't be entered (e.g. it's occupied by a broken train), rv stays next to it,
+which is close enough for repairs.
+*/
+func (rv *RepairVehicle) travelByPath(path []Location, from Location, context *Graph) (Location, Location, bool) {
+	var lastLoc = from
 
-	for _, loc := range path {
+	for i, loc := range path {
 		if rv.moveTo(loc, lastLoc, context) {
 			lastLoc = loc
+		} else if i == len(path)-1 {
+			rv.logf("[Repair] Unable to enter %s, staying next to it", loc.Name())
+			rv.request(loc, release)
+			return lastLoc, nil, true
 		} else {
 			return lastLoc, loc, false
 		}
 	}
-	return nil, lastLoc, true
+	return lastLoc, nil, true
 }
 
-func (rv *RepairVehicle) reserve(path []Location) {
+/*
+reserve reserves the whole path for rv, or none of it. Places are reserved in a global order
+(by name), so that crews reserving overlapping paths don't keep refusing each other, and
+if any of them is refused, the ones reserved so far are released. Returns the refused place, if any.
+*/
+func (rv *RepairVehicle) reserve(path []Location) (Location, bool) {
+	ordered := append([]Location{}, path...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Name() < ordered[j].Name() })
 	rv.logf("[Repair] Reserving path: %v", path)
-	for _, place := range path {
-		rv.request(place, reserve)
-		rv.logf("[Repair] Reserved %s", place.Name())
+	for i, place := range ordered {
+		if !rv.request(place, reserve) {
+			rv.logf("[Repair] %s is reserved or occupied by another vehicle, rolling back", place.Name())
+			rv.release(ordered[:i])
+			return place, false
+		}
 	}
+	rv.logf("[Repair] Path reserved")
+	return nil, true
 }
 
+// release releases rv's reservations of the path
 func (rv *RepairVehicle) release(path []Location) {
-	rv.logf("[Repair] Reserving path: %v", path)
+	rv.logf("[Repair] Releasing path: %v", path)
 	for _, place := range path {
-		rv.request(place, release)
-		rv.logf("[Repair] Reserved %s", place.Name())
+		if rv.request(place, release) {
+			rv.logf("[Repair] Released %s", place.Name())
+		}
+	}
+}
+
+// remainingPath returns the part of path after reached, or all of it if reached isn't on the path
+func remainingPath(path []Location, reached Location) []Location {
+	for i, place := range path {
+		if place == reached {
+			return path[i+1:]
+		}
 	}
+	return path
 }
 
-func (rv *RepairVehicle) repair(target requestHandler, context *Graph) {
+func (rv *RepairVehicle) repair(target requestHandler, failure *failureType, context *Graph) {
 	switch target := target.(type) {
 	case Location:
+		if rv.request(target, check) {
+			rv.logf("[Repair] %s is already back online", target.Name())
+			return
+		}
+		context.emit(&RepairStarted{Crew: rv.id, Target: target.Name()})
 		done := false
 		for !done {
 			rv.request(target, repairStart)
-			rv.logf("[Repair] started repairing %s (%v)", target.Name(), context.repairTime())
-			<-time.After(context.repairTime())
+			context.sleep(context.repairTime(failure))
 			done = rv.request(target, repairDone)
 		}
-		rv.logf("[Repair] %s is back online", target.Name())
-		context.emergencyCtr <- report{delta: -1, key: target.Name()}
+		context.emit(&RepairFinished{Crew: rv.id, Target: target.Name()})
+		context.report(report{delta: -1, key: target.Name()})
 	case *Train:
+		name := fmt.Sprintf("Train #%d", target.id)
+		context.emit(&RepairStarted{Crew: rv.id, Target: name})
 		done := false
 		for !done {
 			rv.request(target, repairStart)
-			rv.logf("[Repair] started repairing Train#%d (%v)", target.id, context.repairTime())
-			<-time.After(context.repairTime())
+			context.sleep(context.repairTime(failure))
 			done = rv.request(target, repairDone)
 		}
-		rv.logf("[Repair] Train#%d is back online", target.id)
-		context.emergencyCtr <- report{delta: -1, key: fmt.Sprintf("Train#%d", target.id)}
+		context.emit(&RepairFinished{Crew: rv.id, Target: name})
+		context.report(report{delta: -1, key: name})
 	}
 
 }
@@ -138,15 +232,24 @@ func (rv *RepairVehicle) moveTo(pos Location, from Location, context *Graph) boo
 		ok = rv.request(pos, take)
 
 		if !rv.request(pos, check) {
-			rv.logf("Unexpected emergency in %s, repairing", pos.Name())
-			rv.repair(pos, context)
+			// only the crew claiming the emergency repairs it - another one may be on its way already
+			if claimed := context.dispatcher.claim(pos, context); claimed != nil {
+				rv.logf("Unexpected emergency in %s, repairing", pos.Name())
+				rv.repair(pos, claimed.failure, context)
+			} else {
+				rv.logf("Unexpected emergency in %s, left to the crew assigned to it", pos.Name())
+			}
 		}
 
 		if !ok {
+			if from != nil && rv.backedOff() {
+				rv.logf("Backing off from %s", pos.Name())
+				return false
+			}
 			ctr++
-			delay := context.waitTime()
+			delay := context.waitTime(rv.rng)
 			rv.logf("Destination occupied, retrying after %v", delay)
-			<-time.After(delay)
+			context.sleep(delay)
 			if ctr >= 5 {
 				return false
 			}
@@ -154,68 +257,49 @@ func (rv *RepairVehicle) moveTo(pos Location, from Location, context *Graph) boo
 
 	}
 	rv.logf("entered %s", pos.Name())
+	rv.backedOff()   // not waiting anymore
 	if from != nil { // => it's not the initial setup
 		// todo: sometimes called twice - investigate
 		rv.request(from, free)
 		rv.request(from, release) // ensure, even if route wasn't actually reserved
-		rv.logf("Released %s", pos.Name())
+		rv.logf("Released %s", from.Name())
 	}
-	<-time.After(context.scaledTime(pos.TravelTime(rv.maxSpeed)))
+	context.sleep(context.duration(rv.travelTime(pos, from)))
 	return true
 }
 
 // findPath finds a shortest (by travel time) sequence of positions from repair team's base
-//	to the target, using Dijkstra's algorithm
+//
+//	to the target, using Dijkstra's algorithm. Reports false if target is unreachable.
 func (rv *RepairVehicle) findPath(source Location, target Location,
-	graph *Graph, blackList []Location) []Location {
+	graph *Graph, blackList []Location) ([]Location, bool) {
 	rv.logf(">>[Repair] blacklist: %v", blackList)
-	queue, items := makeQueue(graph, blackList)
-	rv.logf(">>[Repair] created queue")
-	rv.logf(">>[Repair] source: %v (%v)", source, items[source])
-	queue.update(items[source], 0)
-	// heap.Init(&queue)
-	for len(queue) > 0 {
-		nearest := heap.Pop(&queue).(*item).position
-		// rv.logf("[Repair: Path] nearest: %s (%f)", nearest.Name(), items[nearest].travelTime)
-		neighbours := nearest.neighbours()
-		for _, pos := range neighbours {
-			alternative := items[nearest].travelTime + pos.TravelTime(rv.maxSpeed)
-			if items[pos] != nil && items[pos].travelTime > alternative {
-				queue.update(items[pos], alternative)
-				items[pos].previous = nearest
-			}
-		}
-	}
-	path := make([]Location, 0)
-	last := items[target].position
-	rv.logf("[Repair: Path] source: %s", source.Name())
-	for last != source {
-		rv.logf("[Repair: Path] last: %s", last.Name())
-		path = append(path, last)
-		last = items[last].previous
-	}
-	for i := len(path)/2 - 1; i >= 0; i-- {
-		opp := len(path) - 1 - i
-		path[i], path[opp] = path[opp], path[i]
-	}
-	return path
+	path, _, ok := graph.shortestPath(source, target, rv.maxSpeed, blackList)
+	rv.logf("[Repair: Path] %s -> %s: %v", source.Name(), target.Name(), path)
+	return path, ok
 }
 
-func repairFromJSON(raw map[string]*json.RawMessage, tracks []Track,
-	config *graphConfig) *RepairVehicle {
+func repairFromJSON(raw map[string]*json.RawMessage, base baseVehicle, tracks []Track,
+	path string, errs *ValidationErrors) *RepairVehicle {
 
 	var rv RepairVehicle
 	var id string
-
-	json.Unmarshal(*raw["id"], &rv.id)
-	json.Unmarshal(*raw["maxSpeed"], &rv.maxSpeed)
-	json.Unmarshal(*raw["base"], &id)
+	rv.baseVehicle = base
+	if !decodeField(raw, "base", &id, path, errs) {
+		return nil
+	}
 	for _, track := range tracks {
-		if track.id() == id {
-			rv.Base = track.(*WaitTrack)
-			break
+		if track.id() != id {
+			continue
+		}
+		waitTrack, ok := track.(*WaitTrack)
+		if !ok {
+			errs.add(fieldPath(path, "base"), "base %q is not a wait track", id)
+			return nil
 		}
+		rv.Base = waitTrack
+		return &rv
 	}
-	rv.comm = make(chan bool)
-	return &rv
+	errs.add(fieldPath(path, "base"), "unknown track %q", id)
+	return nil
 }
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LocationState is the state of a single Junction or Track, reconstructed from events
+type LocationState struct {
+	Occupant    int // id of the vehicle inside, 0 if free
+	Reservation int // id of the vehicle holding a reservation, 0 if none
+	Failing     bool
+}
+
+func (s *LocationState) String() string {
+	parts := []string{}
+	if s.Occupant != 0 {
+		parts = append(parts, fmt.Sprintf("occupied by #%d", s.Occupant))
+	}
+	if s.Reservation != 0 {
+		parts = append(parts, fmt.Sprintf("reserved by #%d", s.Reservation))
+	}
+	if s.Failing {
+		parts = append(parts, "FAILING")
+	}
+	if len(parts) == 0 {
+		return "free"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// NetworkState is the state of the network at some point in simulated time
+type NetworkState struct {
+	Time           time.Duration
+	Locations      map[string]*LocationState // location name -> state
+	FailedVehicles map[string]bool           // names of vehicles awaiting repair
+}
+
+/*
+Replay reconstructs the state of the network at simulated time at, by applying
+all events that happened until then. Events must be in the order they were published,
+which isn't strictly the order of their times - events published concurrently may be
+logged slightly out of order, so the whole log is scanned rather than stopping at the
+first later event.
+*/
+func Replay(events []Event, at time.Duration) *NetworkState {
+	state := &NetworkState{
+		Time:           at,
+		Locations:      make(map[string]*LocationState),
+		FailedVehicles: make(map[string]bool),
+	}
+	for _, event := range events {
+		if event.Time() > at {
+			continue
+		}
+		state.apply(event)
+	}
+	return state
+}
+
+// Location returns the state of the named location, creating a free one if it's unknown
+func (state *NetworkState) Location(name string) *LocationState {
+	if state.Locations[name] == nil {
+		state.Locations[name] = &LocationState{}
+	}
+	return state.Locations[name]
+}
+
+func (state *NetworkState) apply(event Event) {
+	switch e := event.(type) {
+	case *VehicleEntered:
+		state.Location(e.Location).Occupant = e.Vehicle
+	case *VehicleLeft:
+		if loc := state.Location(e.Location); loc.Occupant == e.Vehicle {
+			loc.Occupant = 0
+		}
+	case *ReservationMade:
+		state.Location(e.Location).Reservation = e.Vehicle
+	case *ReservationReleased:
+		if loc := state.Location(e.Location); loc.Reservation == e.Vehicle {
+			loc.Reservation = 0
+		}
+	case *FailureRaised:
+		if e.Target == e.Location {
+			state.Location(e.Target).Failing = true
+		} else {
+			state.FailedVehicles[e.Target] = true
+		}
+	case *RepairFinished:
+		if _, ok := state.Locations[e.Target]; ok {
+			state.Locations[e.Target].Failing = false
+		}
+		delete(state.FailedVehicles, e.Target)
+	}
+}
+
+// Names returns names of all locations known to the state, sorted
+func (state *NetworkState) Names() []string {
+	names := make([]string, 0, len(state.Locations))
+	for name := range state.Locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
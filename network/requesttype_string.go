@@ -4,9 +4,9 @@ package network
 
 import "fmt"
 
-const _requestType_name = "takefreereservereleaserepairStartrepairDonecheckfail"
+const _requestType_name = "takefreereservereleaserepairStartrepairDonecheckfailcloseDownreopenmaintainavailableestimateRepair"
 
-var _requestType_index = [...]uint8{0, 4, 8, 15, 22, 33, 43, 48, 52}
+var _requestType_index = [...]uint8{0, 4, 8, 15, 22, 33, 43, 48, 52, 61, 67, 75, 84, 98}
 
 func (i requestType) String() string {
 	i -= 1
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"container/heap"
+)
+
+/*
+shortestPath finds a shortest (by travel time at given speed) sequence of locations
+leading from source to target, using Dijkstra's algorithm. The path doesn't contain source
+and avoids blacklisted locations. Returns the path, its travel time in hours and
+whether target is reachable at all.
+*/
+func (graph *Graph) shortestPath(source Location, target Location, speed float64,
+	blacklist []Location) ([]Location, float64, bool) {
+
+	queue, items := makeQueue(graph, blacklist)
+	if items[source] == nil {
+		return nil, 0, false
+	}
+	queue.update(items[source], 0)
+	for len(queue) > 0 {
+		nearest := heap.Pop(&queue).(*item).position
+		for _, pos := range nearest.neighbours() {
+			alternative := items[nearest].travelTime + pos.TravelTime(speed)
+			if items[pos] != nil && items[pos].travelTime > alternative {
+				queue.update(items[pos], alternative)
+				items[pos].previous = nearest
+			}
+		}
+	}
+	path := make([]Location, 0)
+	if items[target] == nil || (target != source && items[target].previous == nil) {
+		return path, 0, false
+	}
+	for last := target; last != source; last = items[last].previous {
+		path = append(path, last)
+	}
+	for i := len(path)/2 - 1; i >= 0; i-- {
+		opp := len(path) - 1 - i
+		path[i], path[opp] = path[opp], path[i]
+	}
+	return path, items[target].travelTime, true
+}
+
+/*
+parallelTracks returns all tracks of the same kind as track, connecting the same junctions.
+A vehicle may use any of them instead of track.
+*/
+func parallelTracks(track Track) []Track {
+	var tracks []Track
+	for _, other := range track.A().Tracks[track.B().ID] {
+		if _, same := other.(*WaitTrack); same == isWaitTrack(track) {
+			tracks = append(tracks, other)
+		}
+	}
+	return tracks
+}
+
+func isWaitTrack(track Track) bool {
+	_, ok := track.(*WaitTrack)
+	return ok
+}
+
+func containsLocation(list []Location, loc Location) bool {
+	for _, v := range list {
+		if v == loc {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMissing appends to list the locations it doesn't contain yet
+func appendMissing(list []Location, locs ...Location) []Location {
+	for _, loc := range locs {
+		if !containsLocation(list, loc) {
+			list = append(list, loc)
+		}
+	}
+	return list
+}
+
+// trainsUsing counts, for every location, the trains whose routes may lead through it
+func (graph *Graph) trainsUsing() map[Location]int {
+	usage := make(map[Location]int)
+	for _, vehicle := range graph.Vehicles {
+		train, ok := vehicle.(*Train)
+		if !ok {
+			continue
+		}
+		used := make(map[Location]bool)
+		for i, station := range train.Route {
+			next := train.Route[train.nextStationIdx(i)]
+			for _, loc := range graph.legLocations(station, next, train.maxSpeed) {
+				used[loc] = true
+			}
+		}
+		for loc := range used {
+			usage[loc]++
+		}
+	}
+	return usage
+}
+
+// legLocations returns all locations a train may use travelling from one station to the next one
+func (graph *Graph) legLocations(from *Station, to *Station, speed float64) []Location {
+	locs := locations(from.waitTracks())
+	if start, tracks, direct := from.findRouteTo(*to); direct {
+		locs = append(locs, start, tracks[0].oppositeEnd(start))
+		locs = append(locs, locations(tracks)...)
+	} else if path, _, ok := graph.shortestPath(from.A, to.A, speed, nil); ok {
+		for _, loc := range path {
+			if track, ok := loc.(Track); ok {
+				locs = append(locs, locations(parallelTracks(track))...)
+			} else {
+				locs = append(locs, loc)
+			}
+		}
+	}
+	return append(locs, locations(to.waitTracks())...)
+}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+/*
+routingNetwork has two ways from junction 1 to 4: through junction 2 on fast tracks,
+or through junction 3 on a shorter, but slow one
+*/
+const routingNetwork = `{
+	"config": {"clock": "virtual"},
+	"junctions": [
+		{"id": 1, "waitTime": 6}, {"id": 2, "waitTime": 6}, {"id": 3, "waitTime": 6}, {"id": 4, "waitTime": 6}
+	],
+	"tracks": [
+		{"a": 1, "b": 2, "length": 10, "maxSpeed": 100, "id": "t_1_2", "type": "transit"},
+		{"a": 2, "b": 4, "length": 10, "maxSpeed": 100, "id": "t_2_4", "type": "transit"},
+		{"a": 1, "b": 3, "length": 5, "maxSpeed": 20, "id": "t_1_3", "type": "transit"},
+		{"a": 3, "b": 4, "length": 10, "maxSpeed": 100, "id": "t_3_4", "type": "transit"}
+	],
+	"stations": [],
+	"vehicles": []
+}`
+
+func TestShortestPath(t *testing.T) {
+	graph := parseGraph(t, routingNetwork)
+	tests := []struct {
+		name      string
+		from, to  string
+		speed     float64
+		blacklist []string
+		path      string // names of the locations, separated by spaces
+		time      float64
+		reachable bool
+	}{
+		{"fast", "1", "4", 100, nil, "t_1_2 Junction #2 t_2_4 Junction #4", 0.4, true},
+		{"slow", "1", "4", 10, nil, "t_1_3 Junction #3 t_3_4 Junction #4", 1.7, true},
+		{"backwards", "4", "1", 100, nil, "t_2_4 Junction #2 t_1_2 Junction #1", 0.4, true},
+		{"around a blacklisted junction", "1", "4", 100, []string{"2"}, "t_1_3 Junction #3 t_3_4 Junction #4",
+			0.55, true},
+		{"to itself", "1", "1", 100, nil, "", 0, true},
+		{"all ways blacklisted", "1", "4", 100, []string{"2", "3"}, "", 0, false},
+		{"to a blacklisted junction", "1", "4", 100, []string{"4"}, "", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var blacklist []Location
+			for _, id := range test.blacklist {
+				blacklist = append(blacklist, graph.Junction(id))
+			}
+			path, time, reachable := graph.shortestPath(graph.Junction(test.from), graph.Junction(test.to),
+				test.speed, blacklist)
+			var names []string
+			for _, location := range path {
+				names = append(names, location.Name())
+			}
+			if got := strings.Join(names, " "); got != test.path || reachable != test.reachable ||
+				math.Abs(time-test.time) > 1e-9 {
+				t.Errorf("shortestPath() = %q, %v, %v, want %q, %v, %v",
+					got, time, reachable, test.path, test.time, test.reachable)
+			}
+		})
+	}
+}
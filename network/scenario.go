@@ -0,0 +1,278 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// actions of scenario events
+const (
+	scenarioFail  = "fail"
+	scenarioClose = "close"
+	scenarioDelay = "delay"
+)
+
+/*
+scenarioEvent is a disruption scheduled at a given simulated time, e.g. failing a track,
+closing a junction for maintenance or delaying a train. Scenarios allow rehearsing
+disruption plans, independently of randomly generated failures.
+*/
+type scenarioEvent struct {
+	at       time.Duration
+	action   string
+	until    time.Duration // when a closed location reopens
+	delay    time.Duration // how long a delayed train is held
+	location Location      // failed or closed track or junction, nil for trains
+	train    *Train        // failed or delayed train
+	failure  *failureType  // nil for other actions, or to pick a random one
+}
+
+func (e *scenarioEvent) targetName() string {
+	if e.train != nil {
+		return fmt.Sprintf("Train #%d", e.train.id)
+	}
+	return e.location.Name()
+}
+
+/*
+simTime is a simulated time in JSON: either a number of hours,
+or a string "hours:minutes", e.g. "14:00"
+*/
+type simTime float64
+
+func (t *simTime) UnmarshalJSON(raw []byte) error {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return json.Unmarshal(raw, (*float64)(t))
+	}
+	parts := strings.Split(text, ":")
+	hours, err := strconv.Atoi(parts[0])
+	if len(parts) != 2 || err != nil {
+		return fmt.Errorf("invalid time %q, expected hours:minutes", text)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes >= 60 {
+		return fmt.Errorf("invalid time %q, expected hours:minutes", text)
+	}
+	*t = simTime(float64(hours) + float64(minutes)/60)
+	return nil
+}
+
+/*
+LoadScenario reads scenario events from a JSON file with a "scenario" list, in the same
+format as the optional section of a network description, and adds them to the graph's scenario
+*/
+func (graph *Graph) LoadScenario(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	errs := ValidationErrors{}
+	graph.loadScenario(decodeList(raw, "scenario", &errs), &errs)
+	return errs.err()
+}
+
+func (graph *Graph) loadScenario(rawEvents []map[string]*json.RawMessage, errs *ValidationErrors) {
+	for i, rawEvent := range rawEvents {
+		if event := scenarioEventFromJSON(rawEvent, graph, indexPath("scenario", i), errs); event != nil {
+			graph.scenario = append(graph.scenario, event)
+		}
+	}
+	sort.SliceStable(graph.scenario, func(i, j int) bool { return graph.scenario[i].at < graph.scenario[j].at })
+}
+
+func scenarioEventFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) *scenarioEvent {
+
+	var event scenarioEvent
+	var at simTime
+	ok := decodeField(raw, "at", &at, path, errs)
+	if ok && at < 0 {
+		errs.add(fieldPath(path, "at"), "must not be negative")
+		ok = false
+	}
+	event.at = graph.duration(float64(at))
+	ok = decodeField(raw, "action", &event.action, path, errs) && ok
+
+	targets := 0
+	event.location, targets, ok = decodeLocation(raw, graph, path, errs, ok)
+	var trainID int
+	if decodeOptionalField(raw, "train", &trainID, path, errs) {
+		targets++
+		if event.train = graph.trainWithID(trainID); event.train == nil {
+			errs.add(fieldPath(path, "train"), "unknown train %d", trainID)
+			ok = false
+		}
+	}
+	if targets != 1 {
+		errs.add(path, "expected exactly one of track, junction or train")
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	switch event.action {
+	case scenarioFail:
+		element := trainElement
+		if event.location != nil {
+			element = elementKind(event.location)
+		}
+		var name string
+		if decodeOptionalField(raw, "failure", &name, path, errs) {
+			if event.failure = graph.Config.failureNamed(name, element); event.failure == nil {
+				errs.add(fieldPath(path, "failure"), "no failure type %q of a %s", name, element)
+				return nil
+			}
+		}
+	case scenarioClose:
+		if event.location == nil {
+			errs.add(path, "only tracks and junctions can be closed")
+			return nil
+		}
+		var until simTime
+		if !decodeField(raw, "until", &until, path, errs) {
+			return nil
+		}
+		if event.until = graph.duration(float64(until)); event.until <= event.at {
+			errs.add(fieldPath(path, "until"), "must be later than at")
+			return nil
+		}
+	case scenarioDelay:
+		if event.train == nil {
+			errs.add(path, "only trains can be delayed")
+			return nil
+		}
+		var minutes float64
+		if !decodeField(raw, "minutes", &minutes, path, errs) {
+			return nil
+		}
+		if minutes <= 0 {
+			errs.add(fieldPath(path, "minutes"), "must be positive")
+			return nil
+		}
+		event.delay = graph.duration(minutes / 60)
+	default:
+		errs.add(fieldPath(path, "action"), "unknown action %q (expected %q, %q or %q)",
+			event.action, scenarioFail, scenarioClose, scenarioDelay)
+		return nil
+	}
+	return &event
+}
+
+/*
+decodeLocation decodes the optional "track" or "junction" an element of JSON refers to.
+Returns the location, the number of such fields found and ok, unless any of them is invalid.
+*/
+func decodeLocation(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors, ok bool) (Location, int, bool) {
+
+	var location Location
+	found := 0
+	var trackName string
+	if decodeOptionalField(raw, "track", &trackName, path, errs) {
+		found++
+		if track := graph.trackNamed(trackName); track != nil {
+			location = track
+		} else {
+			errs.add(fieldPath(path, "track"), "unknown track %q", trackName)
+			ok = false
+		}
+	}
+	var junction junctionID
+	if decodeOptionalField(raw, "junction", &junction, path, errs) {
+		found++
+		if j := graph.junctionIndex[string(junction)]; j != nil {
+			location = j
+		} else {
+			errs.add(fieldPath(path, "junction"), "unknown junction %q", junction)
+			ok = false
+		}
+	}
+	return location, found, ok
+}
+
+// trackNamed returns the track with given id, or nil if there's none
+func (graph *Graph) trackNamed(name string) Track {
+	for _, track := range graph.Tracks() {
+		if track.Name() == name {
+			return track
+		}
+	}
+	return nil
+}
+
+// trainWithID returns the train with given id, or nil if there's none
+func (graph *Graph) trainWithID(id int) *Train {
+	for _, vehicle := range graph.Vehicles {
+		if train, ok := vehicle.(*Train); ok && train.id == id {
+			return train
+		}
+	}
+	return nil
+}
+
+// runScenario executes the scenario events at their scheduled times
+func (graph *Graph) runScenario() {
+	rng := graph.random("scenario")
+	for _, event := range graph.scenario {
+		if wait := event.at - graph.Clock.Now(); wait > 0 {
+			graph.sleep(wait)
+		}
+		event := event
+		switch event.action {
+		case scenarioFail:
+			failure := event.failure
+			if failure == nil && event.train != nil {
+				failure = graph.Config.randomFailure(trainElement, rng)
+			} else if failure == nil {
+				failure = graph.Config.randomFailure(elementKind(event.location), rng)
+			}
+			if event.train != nil {
+				graph.spawn(func() {
+					select {
+					case event.train.forced <- failure:
+					case <-graph.done:
+					}
+				})
+			} else if !graph.send(event.location, request{kind: fail, failure: failure}) {
+				graph.emit(&Message{Source: "Scenario", Text: event.targetName() + " is already failing"})
+			}
+		case scenarioClose:
+			graph.closeFor(event.location, event.until, "Scenario")
+		case scenarioDelay:
+			graph.spawn(func() {
+				select {
+				case event.train.delays <- event.delay:
+				case <-graph.done:
+				}
+			})
+		}
+	}
+}
+
+// send sends a request on behalf of the simulation itself to a location, and returns the response
+func (graph *Graph) send(target requestHandler, req request) bool {
+	req.c = make(chan bool, 1)
+	select {
+	case target.getRequestChannel() <- req:
+	case <-graph.done:
+		graph.exit()
+	}
+	select {
+	case response := <-req.c:
+		return response
+	case <-graph.done:
+		graph.exit()
+		return false
+	}
+}
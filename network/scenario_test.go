@@ -0,0 +1,95 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+/*
+shuttleNetwork has a train shuttling between stations A and B, a repair crew based at A,
+passengers going from A to B and a scenario breaking the only track between them at 3:00
+*/
+const shuttleNetwork = `{
+	"config": {"clock": "virtual", "seed": 1, "duration": 12, "boardingTime": 30,
+		"failures": [{"name": "broken rail", "elements": ["track"], "severity": 3, "repairTime": 2}]},
+	"junctions": [{"id": 1, "waitTime": 6}, {"id": 2, "waitTime": 6}, {"id": 3, "waitTime": 6}, {"id": 4, "waitTime": 6}],
+	"tracks": [
+		{"a": 1, "b": 2, "waitTime": 6, "id": "w_A_0", "type": "wait"},
+		{"a": 1, "b": 2, "waitTime": 6, "id": "w_A_1", "type": "wait"},
+		{"a": 3, "b": 4, "waitTime": 6, "id": "w_B_0", "type": "wait"},
+		{"a": 2, "b": 3, "length": 20, "maxSpeed": 40, "id": "t_A_B", "type": "transit"}
+	],
+	"stations": [{"a": 1, "b": 2, "name": "A"}, {"a": 3, "b": 4, "name": "B"}],
+	"vehicles": [
+		{"id": 1, "type": "train", "maxSpeed": 40, "capacity": 10, "route": ["A", "B"]},
+		{"id": 2, "type": "repair", "maxSpeed": 40, "base": "w_A_1"}
+	],
+	"scenario": [{"at": "3:00", "action": "fail", "track": "t_A_B", "failure": "broken rail"}],
+	"demand": [{"from": "A", "to": "B", "rate": 2}]
+}`
+
+// runShuttle simulates shuttleNetwork and returns its events, in order of publishing, and summary
+func runShuttle(t *testing.T) ([]Event, *Summary) {
+	t.Helper()
+	graph := parseGraph(t, shuttleNetwork)
+	var events []Event // delivered by the time it's unsubscribed
+	unsubscribe := graph.Events.Subscribe(func(event Event) { events = append(events, event) })
+	summary := graph.Start(context.Background())
+	unsubscribe()
+	return events, summary
+}
+
+func TestScenarioBreaksTrack(t *testing.T) {
+	events, summary := runShuttle(t)
+	var raised *FailureRaised
+	var assigned *RepairAssigned
+	var started *RepairStarted
+	var finished *RepairFinished
+	denied := 0
+	for _, event := range events {
+		switch e := event.(type) {
+		case *FailureRaised:
+			if raised != nil {
+				t.Fatalf("%s failed again at %v", e.Target, e.Time())
+			}
+			raised = e
+		case *RepairAssigned:
+			assigned = e
+		case *RepairStarted:
+			started = e
+		case *RepairFinished:
+			finished = e
+		case *EntryDenied:
+			if e.Location == "t_A_B" && e.Reason == "failing" {
+				denied++
+			}
+		case *VehicleEntered:
+			if e.Location == "t_A_B" && e.Vehicle == 1 && raised != nil && finished == nil {
+				t.Errorf("the train entered the broken track at %v", e.Time())
+			}
+		}
+	}
+	if raised == nil || assigned == nil || started == nil || finished == nil {
+		t.Fatalf("failure raised: %v, assigned: %v, started: %v, finished: %v", raised, assigned, started, finished)
+	}
+	if raised.Time() != 3*time.Hour || raised.Target != "t_A_B" || raised.Kind != "broken rail" || raised.Severity != 3 {
+		t.Errorf("got %+v, want broken rail of t_A_B, of severity 3 at 3h", raised)
+	}
+	if assigned.Time() != 3*time.Hour || assigned.Crew != 2 || assigned.Target != "t_A_B" {
+		t.Errorf("got %+v, want t_A_B assigned to crew 2 at 3h", assigned)
+	}
+	if repair := finished.Time() - started.Time(); repair != 2*time.Hour {
+		t.Errorf("repair took %v, want the broken rail's 2h", repair)
+	}
+	if denied == 0 {
+		t.Error("the train never got to the broken track")
+	}
+	if summary.Failures != 1 || summary.Repairs != 1 || len(summary.ActiveEmergencies) != 0 {
+		t.Errorf("summary counted %d failures, %d repairs and %v active, want 1, 1 and none",
+			summary.Failures, summary.Repairs, summary.ActiveEmergencies)
+	}
+	if want := finished.Time() - raised.Time(); summary.MeanTimeToRepair != want {
+		t.Errorf("mean time to repair %v, want %v", summary.MeanTimeToRepair, want)
+	}
+}
@@ -3,40 +3,175 @@ package network
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"sort"
+	"time"
 )
 
 // Station is a pair of Junctions connected by WaitTracks
 type Station struct {
-	A      *Junction
-	B      *Junction
-	Trains map[Vehicle]struct{}
-	name   string
+	A        *Junction
+	B        *Junction
+	Trains   map[Vehicle]struct{}
+	name     string
+	platform chan *passenger // passengers starting to wait for a train
+	boarding chan boarding
+	expected chan expectation // passenger trains coming to the Station, or leaving it
+	yielding chan departures
 }
 
+// boarding is sent by a train stopping at the Station, to take on the passengers waiting for it
+type boarding struct {
+	train *Train
+	free  int // seats left on the train
+	reply chan boarded
+}
+
+// boarded is the response to boarding
+type boarded struct {
+	passengers []*passenger
+	refused    int // passengers left waiting, because the train is full
+}
+
+// expectation is sent by a passenger train setting off to the Station, and once it leaves it
+type expectation struct {
+	train *Train
+	due   time.Duration // estimated departure from the Station
+	left  bool
+}
+
+// departures is sent by a freight train yielding to faster passenger trains, see Train.letPass
+type departures struct {
+	faster float64       // than this speed
+	before time.Duration // due to leave before that time
+	reply  chan []expectation
+}
 
 /*
-Handle manages task creation and execution at the Station
+Handle manages task creation at the Station and passengers waiting on its platform,
+until the simulation is stopped
 */
 func (s *Station) Handle(ctx *Graph) {
 	tasks := make(chan task)
-	stop := make(chan bool)
-	go ctx.generateTasks(tasks, stop)
+	rng := ctx.random("tasks:" + s.name)
+	ctx.spawn(func() { ctx.generateTasks(tasks, rng) })
+	var waiting []*passenger
+	expected := make(map[*Train]time.Duration)
 	for {
-		task := <-tasks
-		log.Printf("\n\nnew task: %v\n\n", task)
+		select {
+		case task := <-tasks:
+			ctx.jobs.post(task, s, ctx)
+		case p := <-s.platform:
+			waiting = append(waiting, p)
+		case b := <-s.boarding:
+			var result boarded
+			remaining := waiting[:0]
+			for _, p := range waiting {
+				if p.takes(b.train) && len(result.passengers) < b.free {
+					result.passengers = append(result.passengers, p)
+				} else {
+					if p.takes(b.train) {
+						result.refused++
+					}
+					remaining = append(remaining, p)
+				}
+			}
+			waiting = remaining
+			b.reply <- result
+		case e := <-s.expected:
+			if e.left {
+				delete(expected, e.train)
+			} else {
+				expected[e.train] = e.due
+			}
+		case d := <-s.yielding:
+			var due []expectation
+			for train, at := range expected {
+				if train.maxSpeed > d.faster && at < d.before {
+					due = append(due, expectation{train: train, due: at})
+				}
+			}
+			sort.Slice(due, func(i, j int) bool { return due[i].train.id < due[j].train.id })
+			d.reply <- due
+		case <-ctx.done:
+			return
+		}
 	}
 }
 
-func (s *Station) pickWaitTrack() *WaitTrack {
-	return chooseTrack(s.A.Tracks[s.B.ID]).(*WaitTrack)
+// wait puts p on the Station's platform, until one of its trains takes it
+func (s *Station) wait(p *passenger, ctx *Graph) {
+	select {
+	case s.platform <- p:
+	case <-ctx.done:
+		ctx.exit()
+	}
 }
 
+/*
+boardingFor takes the passengers waiting for train t off the platform, as many
+as there are free seats. The rest keep waiting for another train.
+*/
+func (s *Station) boardingFor(t *Train, free int, ctx *Graph) boarded {
+	reply := make(chan boarded, 1)
+	select {
+	case s.boarding <- boarding{t, free, reply}:
+	case <-ctx.done:
+		ctx.exit()
+	}
+	select {
+	case result := <-reply:
+		return result
+	case <-ctx.done:
+		ctx.exit()
+		return boarded{}
+	}
+}
+
+// expect tells the Station when a passenger train is due to leave it, or that it left
+func (s *Station) expect(e expectation, ctx *Graph) {
+	select {
+	case s.expected <- e:
+	case <-ctx.done:
+		ctx.exit()
+	}
+}
+
+/*
+departuresBefore returns the passenger trains faster than speed due to leave the Station before
+the given simulated time, including the ones running late, as estimated when they set off to it
+*/
+func (s *Station) departuresBefore(before time.Duration, speed float64, ctx *Graph) []expectation {
+	reply := make(chan []expectation, 1)
+	select {
+	case s.yielding <- departures{speed, before, reply}:
+	case <-ctx.done:
+		ctx.exit()
+	}
+	select {
+	case due := <-reply:
+		return due
+	case <-ctx.done:
+		ctx.exit()
+		return nil
+	}
+}
+
+// waitTracks returns all WaitTracks between the Station's junctions
 func (s *Station) waitTracks() []Track {
-	return s.A.Tracks[s.B.ID]
+	var tracks []Track
+	for _, track := range s.A.Tracks[s.B.ID] {
+		if _, ok := track.(*WaitTrack); ok {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
 }
 
-func (s *Station) getRouteTo(target Station) (*Junction, []Track) {
+/*
+findRouteTo finds tracks directly connecting the Station with target, and
+the junction of this Station they start at
+*/
+func (s *Station) findRouteTo(target Station) (*Junction, []Track, bool) {
 	choices, ok := s.A.Tracks[target.A.ID]
 	junction := s.A
 	if !ok {
@@ -49,14 +184,11 @@ func (s *Station) getRouteTo(target Station) (*Junction, []Track) {
 	if !ok {
 		choices, ok = s.B.Tracks[target.B.ID]
 	}
-	if !ok {
-		log.Panicf("impossible route: no track exists between %s and %s", s.name, target.name)
-	}
-	return junction, choices
+	return junction, choices, ok
 }
 
 func (s *Station) String() string {
-	return fmt.Sprintf("Station{name: %s, A: %d, B: %d}", s.name, s.A.ID, s.B.ID)
+	return fmt.Sprintf("Station{name: %s, A: %s, B: %s}", s.name, s.A.ID, s.B.ID)
 }
 
 func commonTrains(a Station, b Station) []Vehicle {
@@ -69,15 +201,24 @@ func commonTrains(a Station, b Station) []Vehicle {
 	return common
 }
 
-func stationFromJSON(raw map[string]*json.RawMessage, junctions []*Junction) *Station {
+func stationFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) *Station {
+
 	var station Station
-	var a int
-	var b int
-	json.Unmarshal(*raw["a"], &a)
-	json.Unmarshal(*raw["b"], &b)
-	json.Unmarshal(*raw["name"], &station.name)
-	station.A = junctions[a-1]
-	station.B = junctions[b-1]
+	ok := decodeField(raw, "name", &station.name, path, errs)
+	station.A = decodeJunction(raw, "a", graph, path, errs)
+	station.B = decodeJunction(raw, "b", graph, path, errs)
+	if !ok || station.A == nil || station.B == nil {
+		return nil
+	}
 	station.Trains = make(map[Vehicle]struct{})
+	station.platform = make(chan *passenger)
+	station.boarding = make(chan boarding)
+	station.expected = make(chan expectation)
+	station.yielding = make(chan departures)
+	if len(station.waitTracks()) == 0 {
+		errs.add(path, "no wait tracks between %s and %s", station.A.Name(), station.B.Name())
+		return nil
+	}
 	return &station
 }
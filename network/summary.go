@@ -0,0 +1,146 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Summary describes the outcome of a simulation run
+type Summary struct {
+	Seed              int64
+	SimulatedTime     time.Duration
+	WallTime          time.Duration
+	Failures          int           // number of reported failures
+	Repairs           int           // number of finished repairs
+	MeanTimeToRepair  time.Duration // from a failure until it's repaired
+	ActiveEmergencies []string      // failures still not repaired when the simulation stopped
+	Maintenance       int           // number of maintenance windows used by crews
+	MissedMaintenance int           // number of maintenance windows no crew made it to
+	TasksCreated      int           // number of tasks created at stations
+	TasksDone         int           // number of tasks finished by their workers
+	Stops             []*StopDelays // punctuality of timetabled trains, by train and stop
+	Loads             []*TrainLoads // of trains that carried any passengers, by train
+	Journeys          int           // number of passengers who arrived at their destinations
+	JourneyTime       time.Duration // total, of all arrived passengers
+	WaitingTime       time.Duration // total time arrived passengers waited for trains
+	LostTime          time.Duration // total time arrived passengers lost, compared to direct journeys
+}
+
+// TrainLoads aggregates the load of a train over time
+type TrainLoads struct {
+	Train          int
+	Capacity       int
+	MaxPassengers  int
+	Full           int     // number of departures with no free seats
+	Refused        int     // passengers left behind, because the train was full
+	PassengerHours float64 // sum of passengers carried times their time on board
+	Hours          float64 // since the first departure
+	last           *TrainLoaded
+	since          time.Duration
+}
+
+func (l *TrainLoads) add(load *TrainLoaded) {
+	if l.last == nil {
+		l.since = load.Time()
+	} else {
+		l.PassengerHours += float64(l.last.Passengers) * (load.Time() - l.last.Time()).Hours()
+	}
+	if load.Passengers > l.MaxPassengers {
+		l.MaxPassengers = load.Passengers
+	}
+	if load.Passengers >= l.Capacity {
+		l.Full++
+	}
+	l.Refused += load.Refused
+	l.last = load
+}
+
+// finish accounts for the time since the last departure, when the simulation stops at now
+func (l *TrainLoads) finish(now time.Duration) {
+	l.PassengerHours += float64(l.last.Passengers) * (now - l.last.Time()).Hours()
+	l.Hours = (now - l.since).Hours()
+}
+
+// MeanLoadFactor is the average share of the train's seats taken, over time
+func (l *TrainLoads) MeanLoadFactor() float64 {
+	if l.Hours == 0 {
+		return 0
+	}
+	return l.PassengerHours / (float64(l.Capacity) * l.Hours)
+}
+
+func (l *TrainLoads) String() string {
+	return fmt.Sprintf("Train #%d load: mean %.0f%%, max %d/%d, %d full departures, %d passengers left behind",
+		l.Train, 100*l.MeanLoadFactor(), l.MaxPassengers, l.Capacity, l.Full, l.Refused)
+}
+
+// StopDelays aggregates delays of a timetabled train at a single stop of its route
+type StopDelays struct {
+	Train               int
+	Stop                int // index in the route
+	Station             string
+	Served              int // number of departures from the stop
+	TotalArrivalDelay   time.Duration
+	MaxArrivalDelay     time.Duration
+	TotalDepartureDelay time.Duration
+	MaxDepartureDelay   time.Duration
+}
+
+func (d *StopDelays) add(stop *StopServed) {
+	arrival := stop.Arrival - stop.ScheduledArrival
+	departure := stop.Departure - stop.ScheduledDeparture
+	if d.Served == 0 || arrival > d.MaxArrivalDelay {
+		d.MaxArrivalDelay = arrival
+	}
+	if d.Served == 0 || departure > d.MaxDepartureDelay {
+		d.MaxDepartureDelay = departure
+	}
+	d.Served++
+	d.TotalArrivalDelay += arrival
+	d.TotalDepartureDelay += departure
+}
+
+// MeanArrivalDelay is the average difference between actual and scheduled arrival
+func (d *StopDelays) MeanArrivalDelay() time.Duration {
+	return d.TotalArrivalDelay / time.Duration(d.Served)
+}
+
+// MeanDepartureDelay is the average difference between actual and scheduled departure
+func (d *StopDelays) MeanDepartureDelay() time.Duration {
+	return d.TotalDepartureDelay / time.Duration(d.Served)
+}
+
+func (d *StopDelays) String() string {
+	return fmt.Sprintf("Train #%d, stop %d (%s): served %d times, arrival delay mean %s max %s, departure delay mean %s max %s",
+		d.Train, d.Stop, d.Station, d.Served, formatDelay(d.MeanArrivalDelay()), formatDelay(d.MaxArrivalDelay),
+		formatDelay(d.MeanDepartureDelay()), formatDelay(d.MaxDepartureDelay))
+}
+
+func (s *Summary) String() string {
+	active := "none"
+	if len(s.ActiveEmergencies) > 0 {
+		active = strings.Join(s.ActiveEmergencies, ", ")
+	}
+	summary := fmt.Sprintf("Summary{seed: %d, simulated: %v, wall: %v, failures: %d, repairs: %d, "+
+		"mean time to repair: %v, active emergencies: %s}",
+		s.Seed, s.SimulatedTime, s.WallTime, s.Failures, s.Repairs, s.MeanTimeToRepair, active)
+	if s.Maintenance > 0 || s.MissedMaintenance > 0 {
+		summary += fmt.Sprintf("\n  maintenance: %d done, %d missed", s.Maintenance, s.MissedMaintenance)
+	}
+	if s.TasksCreated > 0 {
+		summary += fmt.Sprintf("\n  tasks: %d created, %d done", s.TasksCreated, s.TasksDone)
+	}
+	if s.Journeys > 0 {
+		summary += fmt.Sprintf("\n  passengers: %d arrived, mean journey %v, mean wait %v, %.0f passenger-minutes lost",
+			s.Journeys, s.JourneyTime/time.Duration(s.Journeys), s.WaitingTime/time.Duration(s.Journeys),
+			s.LostTime.Minutes())
+	}
+	for _, stop := range s.Stops {
+		summary += "\n  " + stop.String()
+	}
+	for _, load := range s.Loads {
+		summary += "\n  " + load.String()
+	}
+	return summary
+}
@@ -23,9 +23,9 @@ type taskConfig struct {
 	DurationScaleRange float64
 }
 
-func (tc *taskConfig) randomTask() task {
+func (tc *taskConfig) randomTask(rng *rand.Rand) task {
 	randInRange := func(min, max float64) float64 {
-		return rand.Float64()*(max-min) + min
+		return rng.Float64()*(max-min) + min
 	}
 	workerScale := randInRange(1-tc.WorkerScaleRange, 1+tc.WorkerScaleRange)
 	durationScale := randInRange(1-tc.DurationScaleRange, 1+tc.DurationScaleRange)
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"encoding/json"
+	"time"
+)
+
+/*
+Timetable is a schedule of a Train's stops along its route, repeated every Period hours.
+Times of stops are in hours since the beginning of a lap.
+*/
+type Timetable struct {
+	Period float64
+	Stops  []ScheduledStop
+}
+
+// ScheduledStop is the scheduled arrival to and departure from a single station of the route
+type ScheduledStop struct {
+	Station   string
+	Arrival   float64
+	Departure float64
+}
+
+// arrival returns the scheduled arrival to stop idx in given lap, in simulated time
+func (tt *Timetable) arrival(graph *Graph, lap int, idx int) time.Duration {
+	return graph.duration(float64(lap)*tt.Period + tt.Stops[idx].Arrival)
+}
+
+// departure returns the scheduled departure from stop idx in given lap, in simulated time
+func (tt *Timetable) departure(graph *Graph, lap int, idx int) time.Duration {
+	return graph.duration(float64(lap)*tt.Period + tt.Stops[idx].Departure)
+}
+
+/*
+timetableFromJSON decodes the optional timetable of a train and checks that it
+matches the route. Returns nil if there's none or it's invalid.
+*/
+func timetableFromJSON(raw map[string]*json.RawMessage, route []*Station, path string,
+	errs *ValidationErrors) *Timetable {
+
+	var timetable Timetable
+	if !decodeOptionalField(raw, "timetable", &timetable, path, errs) {
+		return nil
+	}
+	path = fieldPath(path, "timetable")
+	ok := true
+	if timetable.Period <= 0 {
+		errs.add(fieldPath(path, "period"), "must be positive")
+		ok = false
+	}
+	if len(timetable.Stops) != len(route) {
+		errs.add(fieldPath(path, "stops"), "expected %d stops, one for each station of the route, got %d",
+			len(route), len(timetable.Stops))
+		return nil
+	}
+	previous := 0.0
+	for i, stop := range timetable.Stops {
+		stopPath := indexPath(fieldPath(path, "stops"), i)
+		if stop.Station != route[i].name {
+			errs.add(fieldPath(stopPath, "station"), "expected station %s, as in the route", route[i].name)
+			ok = false
+		}
+		if stop.Arrival < previous {
+			errs.add(fieldPath(stopPath, "arrival"), "must not be before the previous stop")
+			ok = false
+		}
+		if stop.Departure < stop.Arrival {
+			errs.add(fieldPath(stopPath, "departure"), "must not be before the arrival")
+			ok = false
+		}
+		previous = stop.Departure
+	}
+	if ok && previous >= timetable.Period+timetable.Stops[0].Arrival {
+		errs.add(fieldPath(path, "period"), "lap must end after the last departure")
+		ok = false
+	}
+	if !ok {
+		return nil
+	}
+	return &timetable
+}
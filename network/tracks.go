@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
 )
 
 /*
@@ -43,7 +43,7 @@ func (track *baseTrack) oppositeEnd(of *Junction) *Junction {
 	} else if of == track.b {
 		return track.a
 	}
-	log.Panicf("baseTrack.oppositeEnd: %d is not an end of %s (%d and %d are)",
+	log.Panicf("baseTrack.oppositeEnd: %s is not an end of %s (%s and %s are)",
 		of.ID, track.Name(), track.a.ID, track.b.ID)
 	return nil // not reachable due to log.Panicf, but tools complain
 }
@@ -65,90 +65,162 @@ func (track *baseTrack) neighbours() []Location {
 	return []Location{track.a, track.b}
 }
 
-func chooseTrack(tracks []Track) Track {
-	idx := rand.Intn(len(tracks))
-	return tracks[idx]
+// locations converts a list of tracks to a list of locations
+func locations(tracks []Track) []Location {
+	list := make([]Location, len(tracks))
+	for i, track := range tracks {
+		list[i] = track
+	}
+	return list
 }
 
 // WaitTrack is a Track with constant time of traversal, independent on the Vehicle's speed
 type WaitTrack struct {
 	baseTrack
 	WaitTime float64
+	siding   bool // kept for freight trains letting faster trains pass, see Train.letPass
 }
 
-//  e - implements Position.TravelTime
+// e - implements Position.TravelTime
 func (wt *WaitTrack) TravelTime(speed float64) float64 {
 	return wt.WaitTime
 }
 
 func (wt *WaitTrack) String() string {
-	return fmt.Sprintf("WaitTrack{id: %s, A: %d, B: %d, waitTime: %.2f}",
-		wt._id, wt.a.ID, wt.b.ID, wt.WaitTime)
+	return fmt.Sprintf("WaitTrack{id: %s, A: %s, B: %s, waitTime: %.2f, siding: %t}",
+		wt._id, wt.a.ID, wt.b.ID, wt.WaitTime, wt.siding)
 }
 
-// TransitTrack is a Track with time of traversal depending on it's length,``
+// TransitTrack is a Track with time of traversal depending on it's length,
 // and Vehicle's speed, possibly limited by the track
 type TransitTrack struct {
 	baseTrack
 	Length   float64
 	MaxSpeed float64
+	SpeedAtA float64 // speed limit at the end at junction A, 0 if there's none
+	SpeedAtB float64 // speed limit at the end at junction B, 0 if there's none
 }
 
 // TravelTime - implements Position.TravelTime
 func (tt *TransitTrack) TravelTime(speed float64) float64 {
-	return tt.Length / tt.MaxSpeed
+	return tt.Length / math.Min(speed, tt.MaxSpeed)
+}
+
+/*
+kinematicTravelTime - implements kinematicLocation.kinematicTravelTime.
+Vehicles enter the track at the speed limit of the end they come from, accelerate
+to cruise speed and brake in time to leave it at the limit of the other end.
+Vehicles coming from nowhere, when they're set up, go from A to B.
+*/
+func (tt *TransitTrack) kinematicTravelTime(from Location, speed float64, acceleration float64,
+	braking float64) float64 {
+
+	cruise := math.Min(speed, tt.MaxSpeed)
+	limit := func(limit float64) float64 {
+		if limit <= 0 {
+			return cruise
+		}
+		return math.Min(limit, cruise)
+	}
+	entry, exit := tt.SpeedAtA, tt.SpeedAtB
+	if from != nil && from == Location(tt.b) {
+		entry, exit = exit, entry
+	}
+	return kinematicTime(tt.Length, limit(entry), cruise, limit(exit), acceleration, braking)
 }
 
 func (tt *TransitTrack) String() string {
-	return fmt.Sprintf("TransitTrack{id: %s, A: %d, B: %d, length: %.2f, maxSpeed: %.2f}",
+	return fmt.Sprintf("TransitTrack{id: %s, A: %s, B: %s, length: %.2f, maxSpeed: %.2f}",
 		tt._id, tt.a.ID, tt.b.ID, tt.Length, tt.MaxSpeed)
 }
 
-func trackFromJSON(raw map[string]*json.RawMessage, junctions []*Junction, config *graphConfig) Track {
+func trackFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) Track {
+
 	var kind string
-	var track Track
-	json.Unmarshal(*raw["type"], &kind)
+	if !decodeField(raw, "type", &kind, path, errs) {
+		return nil
+	}
 	switch kind {
 	case "transit":
-		track = transitTrackFromJSON(raw, junctions, config)
+		if track := transitTrackFromJSON(raw, graph, path, errs); track != nil {
+			return track
+		}
 	case "wait":
-		track = waitTrackFromJSON(raw, junctions, config)
+		if track := waitTrackFromJSON(raw, graph, path, errs); track != nil {
+			return track
+		}
 	default:
-		log.Panicln("Unknown track type: ", kind)
+		errs.add(fieldPath(path, "type"), "unknown track type %q", kind)
 	}
-	return track
+	return nil
+}
+
+// baseTrackFromJSON reads the fields common to all kinds of tracks
+func baseTrackFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) (baseTrack, bool) {
+
+	var track baseTrack
+	track.basePosition = basePosition{graph.Config, -1, make(chan request), make(chan request),
+		failureModelFromJSON(raw, path, errs)}
+	ok := decodeField(raw, "id", &track._id, path, errs)
+	track.a = decodeJunction(raw, "a", graph, path, errs)
+	track.b = decodeJunction(raw, "b", graph, path, errs)
+	if track.a != nil && track.a == track.b {
+		errs.add(path, "track connects %s to itself", track.a.Name())
+		ok = false
+	}
+	return track, ok && track.a != nil && track.b != nil
 }
 
-func transitTrackFromJSON(raw map[string]*json.RawMessage, junctions []*Junction,
-	config *graphConfig) *TransitTrack {
+func transitTrackFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) *TransitTrack {
 
-	var a int
-	var b int
 	var track TransitTrack
-	track.basePosition = basePosition{config, -1, make(chan request), make(chan request)}
-	json.Unmarshal(*raw["a"], &a)
-	json.Unmarshal(*raw["b"], &b)
-	json.Unmarshal(*raw["length"], &track.Length)
-	json.Unmarshal(*raw["maxSpeed"], &track.MaxSpeed)
-	json.Unmarshal(*raw["id"], &track._id)
-	track.a = junctions[a-1]
-	track.b = junctions[b-1]
+	var ok bool
+	track.baseTrack, ok = baseTrackFromJSON(raw, graph, path, errs)
+	if !decodeField(raw, "length", &track.Length, path, errs) {
+		ok = false
+	} else if track.Length <= 0 {
+		errs.add(fieldPath(path, "length"), "must be positive")
+		ok = false
+	}
+	if !decodeField(raw, "maxSpeed", &track.MaxSpeed, path, errs) {
+		ok = false
+	} else if track.MaxSpeed <= 0 {
+		errs.add(fieldPath(path, "maxSpeed"), "must be positive")
+		ok = false
+	}
+	if decodeOptionalField(raw, "speedAtA", &track.SpeedAtA, path, errs) && track.SpeedAtA <= 0 {
+		errs.add(fieldPath(path, "speedAtA"), "must be positive")
+		ok = false
+	}
+	if decodeOptionalField(raw, "speedAtB", &track.SpeedAtB, path, errs) && track.SpeedAtB <= 0 {
+		errs.add(fieldPath(path, "speedAtB"), "must be positive")
+		ok = false
+	}
+	if !ok {
+		return nil
+	}
 	return &track
 }
 
-func waitTrackFromJSON(raw map[string]*json.RawMessage, junctions []*Junction,
-	config *graphConfig) *WaitTrack {
+func waitTrackFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) *WaitTrack {
 
-	var a int
-	var b int
 	var track WaitTrack
-	track.basePosition = basePosition{config, -1, make(chan request), make(chan request)}
-	json.Unmarshal(*raw["a"], &a)
-	json.Unmarshal(*raw["b"], &b)
-	json.Unmarshal(*raw["waitTime"], &track.WaitTime)
-	json.Unmarshal(*raw["id"], &track._id)
+	var ok bool
+	track.baseTrack, ok = baseTrackFromJSON(raw, graph, path, errs)
+	if !decodeField(raw, "waitTime", &track.WaitTime, path, errs) {
+		ok = false
+	} else if track.WaitTime < 0 {
+		errs.add(fieldPath(path, "waitTime"), "must not be negative")
+		ok = false
+	}
+	decodeOptionalField(raw, "siding", &track.siding, path, errs)
+	if !ok {
+		return nil
+	}
 	track.WaitTime /= 60 // minutes in json -> hours
-	track.a = junctions[a-1]
-	track.b = junctions[b-1]
 	return &track
 }
@@ -3,16 +3,31 @@ package network
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"strings"
 	"time"
 )
 
 // Train is a basic vehicle travelling through the network along a predefined route
 type Train struct {
 	baseVehicle
-	Route    []*Station
-	capacity int
-	accident chan bool
-	requests chan request
+	Route      []*Station
+	Timetable  *Timetable // nil for trains running as fast as they can
+	capacity   int        // of passengers, 0 for freight trains
+	cargo      *cargo     // nil for passenger trains
+	loaded     bool
+	accident   chan bool
+	failures   *rand.Rand
+	requests   chan request
+	enteredAt  time.Duration // when the train entered its current location
+	model      failureModel  // nil to use the one configured for trains
+	forced     chan *failureType
+	delays     chan time.Duration
+	passengers []*passenger
+}
+
+func (t *Train) ownFailureModel() failureModel {
+	return t.model
 }
 
 func (t *Train) String() string {
@@ -24,6 +39,12 @@ func (t *Train) String() string {
 		}
 
 	}
+	if t.Timetable != nil {
+		route += fmt.Sprintf(" every %.2fh", t.Timetable.Period)
+	}
+	if t.cargo != nil {
+		return fmt.Sprintf("Train{maxSpeed: %f, cargo: %v, route: %s}", t.maxSpeed, t.cargo, route)
+	}
 	return fmt.Sprintf("Train{maxSpeed: %f, capacity: %d, route: %s}", t.maxSpeed, t.capacity, route)
 }
 
@@ -34,29 +55,90 @@ func (t *Train) Handle(ctx *Graph) {
 	var curLocation Location
 	var stationIdx = 0
 	var curStation = t.Route[stationIdx]
+	t.setUp(ctx)
+	t.failures = ctx.random(fmt.Sprintf("failures:vehicle:%d", t.id))
 
-	curLocation = t.travelToOneOf(curStation.waitTracks(), nil, ctx)
+	curLocation = t.travelToOneOf(locations(t.waitTracksAt(curStation)), nil, ctx)
+	arrival := t.enteredAt
 	t.logf("Starting at %s", curLocation.Name())
 	fails := make(chan bool)
-	go ctx.generateFailures(fails)
+	ctx.generateFailures(fails, ctx.failureModelOf(t), t.failures)
 	laps := 0
+	if t.cargo == nil {
+		curStation.expect(expectation{train: t, due: t.dueAt(stationIdx, laps, arrival, ctx)}, ctx)
+	}
 	for {
-		stationIdx = t.nextStationIdx(stationIdx)
-		nextStation := t.Route[stationIdx]
+		if t.cargo != nil {
+			t.handleCargo(curStation, ctx)
+		} else {
+			alighted := t.alight(curStation, ctx)
+			t.dwell(alighted+t.board(curStation, ctx), ctx)
+		}
+		if t.Timetable != nil { // boarding counts towards the scheduled stop
+			t.holdUntil(t.Timetable.departure(ctx, laps, stationIdx), ctx)
+		}
+		nextStation := t.Route[t.nextStationIdx(stationIdx)]
+		if t.cargo != nil {
+			t.letPass(curStation, curLocation, nextStation, ctx)
+		}
 		t.logf("Next station: %s", nextStation.name)
-		start, trackChoices := curStation.getRouteTo(*nextStation)
-		curLocation = t.travelTo(start, curLocation, false, ctx)
-		t.maybeFailAndRecover(curLocation, fails, ctx)
-		curLocation = t.travelToOneOf(trackChoices, curLocation, ctx)
-		t.maybeFailAndRecover(curLocation, fails, ctx)
-		curLocation = t.travelTo(curLocation.(Track).oppositeEnd(start), curLocation, false, ctx)
-		t.maybeFailAndRecover(curLocation, fails, ctx)
-		curLocation = t.travelTo(nextStation.pickWaitTrack(), curLocation, false, ctx)
-		t.maybeFailAndRecover(curLocation, fails, ctx)
+		route, ok := t.routeTo(curStation, nextStation, curLocation, nil, ctx)
+		for !ok { // the route can't be driven, keep the train waiting rather than stop the simulation
+			delay := ctx.waitTime(t.rng) * 2
+			t.logf("No way from %s to station %s, retrying after %v", curLocation.Name(), nextStation.name, delay)
+			ctx.sleep(delay)
+			route, ok = t.routeTo(curStation, nextStation, curLocation, nil, ctx)
+		}
+		var avoid []Location // failing or closed locations found on the way
+		departed := false
+		for i := 0; i < len(route); i++ {
+			dst := t.travelToOneOf(route[i], curLocation, ctx)
+			if dst == nil { // failing, closed or backing off, look for another way
+				offline := t.offline(route[i])
+				avoid = appendMissing(avoid, offline...)
+				var contested []Location // merely occupied, avoided by this detour only
+				if len(offline) == 0 {
+					contested = route[i]
+				}
+				if detour := t.reroute(nextStation, curLocation, route[i:], avoid, contested, ctx); detour != nil {
+					route, i = detour, -1
+				} else {
+					delay := ctx.waitTime(t.rng) * 2
+					if contested != nil {
+						t.logf("Backed off, retrying after %v", delay)
+					} else {
+						t.logf("Waiting for repairs, retrying after %v", delay)
+					}
+					ctx.sleep(delay)
+					i--
+				}
+				continue
+			}
+			curLocation = dst
+			if !departed && t.cargo == nil {
+				t.announce(stationIdx, laps, route[i+1:], curLocation, ctx)
+			}
+			if !departed && t.Timetable != nil {
+				ctx.serveStop(&StopServed{
+					Train:              t.id,
+					Stop:               stationIdx,
+					Station:            curStation.name,
+					ScheduledArrival:   t.Timetable.arrival(ctx, laps, stationIdx),
+					Arrival:            arrival,
+					ScheduledDeparture: t.Timetable.departure(ctx, laps, stationIdx),
+					Departure:          t.enteredAt,
+				})
+			}
+			departed = true
+			t.maybeFailAndRecover(curLocation, fails, ctx)
+			t.maybeHold(curLocation, ctx)
+		}
+		arrival = t.enteredAt
+		stationIdx = t.nextStationIdx(stationIdx)
 		curStation = nextStation
 
 		t.logf("Arrived at station %s", curStation.name)
-		if curStation == t.Route[0] {
+		if stationIdx == 0 {
 			laps++
 			t.logf("Route completed (%d times so far)", laps)
 		}
@@ -79,55 +161,287 @@ func (t *Train) getRWRequestChannel() chan request {
 }
 
 func (t *Train) travelTo(location Location, from Location, once bool, ctx *Graph) Location {
-	delay := ctx.waitTime()
+	delay := ctx.waitTime(t.rng)
 
 	// enter the new location
 	t.logf("Requesting entry: %s", location.Name())
-	for !t.request(location, take) {
+	for !t.send(location, request{kind: take, yielding: t.cargo != nil}) { // freight trains let others in first
 		t.logf("%s - entry denied", location.Name())
 		if once {
 			return nil
 		}
+		if from != nil && t.backedOff() {
+			t.logf("Backing off from %s", location.Name())
+			return nil
+		}
 		// check failure reason
-		if failing := !t.request(location, check); failing {
+		if failing := !t.request(location, available); failing && from != nil && ctx.Config.rerouting() {
+			t.logf("Destination offline")
+			return nil
+		} else if failing {
 			t.logf("Destination offline, retrying after %v", delay*2)
-			<-time.After(delay * 2)
+			ctx.sleep(delay * 2)
 		} else {
 			t.logf("Destination occupied, retrying after %v", delay)
-			<-time.After(delay)
+			ctx.sleep(delay)
 		}
 		continue
 	}
 	t.logf("Arrived at %s", location.Name())
+	t.backedOff() // not waiting anymore
+	t.enteredAt = ctx.Clock.Now()
 	if from != nil { // => we're not doing the initial setup
 		// free the previous one
 		for !t.request(from, free) {
 			t.logf("Unable to leave previous location: %s - retrying after %v", from, delay)
-			<-time.After(delay)
+			ctx.sleep(delay)
 		}
 		t.logf("Left %s", from.Name())
 	}
 
 	// simulate travel through the new location
-	travelTime := ctx.scaledTime(location.TravelTime(t.maxSpeed))
+	travelTime := ctx.duration(t.travelTime(location, from))
 	t.logf("Traversing %s, ETA: %v", location.Name(), travelTime)
-	<-time.After(travelTime)
+	ctx.sleep(travelTime)
 
 	return location
 }
 
-func (t *Train) travelToOneOf(trackChoices []Track, from Location, ctx *Graph) Location {
-	chosen := chooseTrack(trackChoices)
+/*
+travelToOneOf moves the train to any of the interchangeable choices, trying another
+one whenever the chosen one is unavailable. If rerouting is enabled and all of them
+are failing, or the train is told to back off, it returns nil instead.
+*/
+func (t *Train) travelToOneOf(choices []Location, from Location, ctx *Graph) Location {
+	if len(choices) == 1 {
+		return t.travelTo(choices[0], from, false, ctx)
+	}
+	chosen := choices[t.rng.Intn(len(choices))]
 	dst := t.travelTo(chosen, from, true, ctx)
 	for dst == nil {
-		<-time.After(ctx.waitTime())
-		chosen = chooseTrack(trackChoices)
+		if from != nil && ctx.Config.rerouting() && t.allFailing(choices) {
+			t.logf("All of %d tracks offline", len(choices))
+			return nil
+		}
+		if from != nil && t.backedOff() {
+			t.logf("Backing off from %d tracks", len(choices))
+			return nil
+		}
+		ctx.sleep(ctx.waitTime(t.rng))
+		chosen = choices[t.rng.Intn(len(choices))]
 		t.logf("Trying another track: %s", chosen.Name())
 		dst = t.travelTo(chosen, from, true, ctx)
 	}
 	return dst
 }
 
+/*
+waitTracksAt returns the wait tracks of station the train may stop at: its sidings for freight trains,
+and the other ones for passenger trains - unless the station has only one kind of them
+*/
+func (t *Train) waitTracksAt(station *Station) []Track {
+	var preferred []Track
+	for _, track := range station.waitTracks() {
+		if track.(*WaitTrack).siding == (t.cargo != nil) {
+			preferred = append(preferred, track)
+		}
+	}
+	if len(preferred) == 0 {
+		return station.waitTracks()
+	}
+	return preferred
+}
+
+// offline returns the choices that are failing or closed
+func (t *Train) offline(choices []Location) []Location {
+	var offline []Location
+	for _, choice := range choices {
+		if !t.request(choice, available) {
+			offline = append(offline, choice)
+		}
+	}
+	return offline
+}
+
+// allFailing checks whether all of the choices are failing
+func (t *Train) allFailing(choices []Location) bool {
+	for _, choice := range choices {
+		if t.request(choice, available) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+routeTo plans the way from station cur to a wait track of next, as a list of steps -
+each a list of interchangeable locations, including the last one: any free wait track will do,
+e.g. when a repair crew is parked at its base. Stations connected directly are reached through
+any of the tracks between them, others through a shortest path in the network, passing
+through other stations on the way without stopping. Locations in avoid are never used,
+which may leave no way at all.
+*/
+func (t *Train) routeTo(cur *Station, next *Station, from Location, avoid []Location,
+	ctx *Graph) ([][]Location, bool) {
+
+	if len(avoid) == 0 {
+		if start, tracks, direct := cur.findRouteTo(*next); direct {
+			return [][]Location{
+				{start},
+				locations(tracks),
+				{tracks[0].oppositeEnd(start)},
+				locations(t.waitTracksAt(next)),
+			}, true
+		}
+	}
+	var waitTracks []Location
+	for _, track := range t.waitTracksAt(next) {
+		if !containsLocation(avoid, track) {
+			waitTracks = append(waitTracks, track)
+		}
+	}
+	var path []Location
+	best := 0.0
+	for _, end := range []*Junction{next.A, next.B} {
+		candidate, travelTime, reachable := ctx.shortestPath(from, end, t.maxSpeed, avoid)
+		if reachable && (path == nil || travelTime < best) {
+			path, best = candidate, travelTime
+		}
+	}
+	if path == nil || len(waitTracks) == 0 {
+		return nil, false
+	}
+	names := make([]string, len(path))
+	steps := make([][]Location, 0, len(path)+1)
+	for i, loc := range path {
+		names[i] = loc.Name()
+		if track, ok := loc.(Track); ok {
+			var choices []Location
+			for _, parallel := range parallelTracks(track) {
+				if !containsLocation(avoid, parallel) {
+					choices = append(choices, parallel)
+				}
+			}
+			steps = append(steps, choices)
+		} else {
+			steps = append(steps, []Location{loc})
+		}
+	}
+	t.logf("Route to %s: %s", next.name, strings.Join(names, " -> "))
+	return append(steps, waitTracks), true
+}
+
+/*
+reroute looks for a way to station next avoiding the failing or closed locations, and the contested
+ones the train backed off from, to replace the remaining steps of the current route. Detours around
+failures follow the rerouting policy, while ones around contested locations are taken whenever
+there's one - they resolve deadlocks. Returns nil if there's no way, or if the policy prefers
+waiting for repairs.
+*/
+func (t *Train) reroute(next *Station, from Location, remaining [][]Location, avoid []Location,
+	contested []Location, ctx *Graph) [][]Location {
+
+	if contested == nil && !ctx.Config.rerouting() {
+		return nil
+	}
+	detour, ok := t.routeTo(nil, next, from, appendMissing(append([]Location{}, avoid...), contested...), ctx)
+	if !ok {
+		t.logf("No detour to %s", next.name)
+		return nil
+	}
+	extra := t.routeTime(detour, from) - t.routeTime(remaining, from)
+	if contested != nil {
+		t.logf("Going around %s to %s, %.2fh longer", contested[0].Name(), next.name, extra)
+		return detour
+	}
+	if ctx.Config.Rerouting == rerouteShorter {
+		if repairs := t.repairsLeft(avoid); extra >= repairs {
+			t.logf("Detour to %s takes %.2fh longer than repairs (%.2fh), waiting", next.name, extra, repairs)
+			return nil
+		}
+	}
+	names := make([]string, len(avoid))
+	for i, loc := range avoid {
+		names[i] = loc.Name()
+	}
+	ctx.emit(&Rerouted{Train: t.id, Station: next.name, Avoiding: names, Detour: extra})
+	return detour
+}
+
+// repairsLeft estimates how long it takes, in hours, until all of the failing locations are repaired
+func (t *Train) repairsLeft(failing []Location) float64 {
+	var left time.Duration
+	for _, loc := range failing {
+		estimate := make(chan time.Duration, 1)
+		t.send(loc, request{kind: estimateRepair, estimate: estimate})
+		if e := <-estimate; e > left {
+			left = e
+		}
+	}
+	return left.Hours()
+}
+
+// routeTime estimates the travel time of a route starting from location from, in hours
+func (t *Train) routeTime(route [][]Location, from Location) float64 {
+	total := 0.0
+	for _, choices := range route {
+		total += t.travelTime(choices[0], from)
+		from = choices[0]
+	}
+	return total
+}
+
+// alight lets off the passengers travelling to station, and returns their number
+func (t *Train) alight(station *Station, ctx *Graph) int {
+	remaining := t.passengers[:0]
+	for _, p := range t.passengers {
+		if p.to == station {
+			if p.worker != 0 {
+				ctx.emit(&WorkerAlighted{Worker: p.worker, Train: t.id, Station: station.name})
+			}
+			ctx.settle(func() { p.alighted <- t }) // let it go on with its journey before the next one does
+		} else {
+			remaining = append(remaining, p)
+		}
+	}
+	alighted := len(t.passengers) - len(remaining)
+	t.passengers = remaining
+	return alighted
+}
+
+/*
+board takes on the passengers waiting at station for the train, as long as there are free seats,
+and returns their number
+*/
+func (t *Train) board(station *Station, ctx *Graph) int {
+	result := station.boardingFor(t, t.capacity-len(t.passengers), ctx)
+	for _, p := range result.passengers {
+		if p.worker != 0 {
+			ctx.emit(&WorkerBoarded{Worker: p.worker, Train: t.id, Station: station.name})
+		}
+		p.boardedAt = ctx.Clock.Now()
+		t.passengers = append(t.passengers, p)
+	}
+	ctx.reportLoad(&TrainLoaded{Train: t.id, Station: station.name, Passengers: len(t.passengers),
+		Capacity: t.capacity, Refused: result.refused})
+	return len(result.passengers)
+}
+
+// dwell keeps the train at its stop while n passengers get on or off
+func (t *Train) dwell(n int, ctx *Graph) {
+	if n > 0 && ctx.Config.BoardingTime > 0 {
+		ctx.sleep(time.Duration(float64(n) * ctx.Config.BoardingTime * float64(time.Second)))
+	}
+}
+
+// holdUntil keeps the train in its current location until the scheduled departure
+func (t *Train) holdUntil(departure time.Duration, ctx *Graph) {
+	if wait := departure - ctx.Clock.Now(); wait > 0 {
+		t.logf("Holding until scheduled departure at %s", formatSimTime(departure))
+		ctx.sleep(wait)
+	}
+}
+
 func (t *Train) nextStationIdx(idx int) int {
 	return (idx + 1) % len(t.Route)
 }
@@ -135,50 +449,121 @@ func (t *Train) nextStationIdx(idx int) int {
 func (t *Train) maybeFailAndRecover(curLocation Location, fails chan bool, ctx *Graph) {
 	select {
 	case <-fails:
-		ctx.emergencyCtr <- report{delta: 1, key: fmt.Sprintf("Train #%d", t.id)}
-		ctx.Emergency <- emergency{curLocation, t}
-		t.awaitRepair()
-		go ctx.generateFailures(fails)
+		t.failAndRecover(curLocation, ctx.Config.randomFailure(trainElement, t.failures), ctx)
+		ctx.generateFailures(fails, ctx.failureModelOf(t), t.failures)
+	case failure := <-t.forced:
+		t.failAndRecover(curLocation, failure, ctx)
 	default:
 		// hurray, no train crash! (for now)
 	}
 }
 
-/**
+func (t *Train) failAndRecover(curLocation Location, failure *failureType, ctx *Graph) {
+	ctx.emit(&FailureRaised{Target: fmt.Sprintf("Train #%d", t.id), Location: curLocation.Name(),
+		Kind: failure.Name, Severity: failure.Severity})
+	ctx.report(report{delta: 1, key: fmt.Sprintf("Train #%d", t.id)})
+	ctx.raiseEmergency(emergency{curLocation, t, failure})
+	t.awaitRepair()
+}
+
+// maybeHold keeps the train in its current location, if it was asked to wait
+func (t *Train) maybeHold(curLocation Location, ctx *Graph) {
+	select {
+	case delay := <-t.delays:
+		ctx.emit(&TrainDelayed{Train: t.id, Location: curLocation.Name(), Delay: delay})
+		ctx.sleep(delay)
+	default:
+	}
+}
+
+/*
 AwaitRepair causes train to ignore all requests and wait in its current
 location until it is repaired
 */
 func (t *Train) awaitRepair() {
-	var req request
-	req = <-t.requests
+	req := t.nextRequest()
 	for req.kind != repairStart {
 		req.c <- false
-		req = <-t.requests
+		req = t.nextRequest()
 	}
 	req.c <- true
-	req = <-t.requests
+	req = t.nextRequest()
 	for req.kind != repairDone {
 		req.c <- false
-		req = <-t.requests
+		req = t.nextRequest()
 	}
 	req.c <- true
+}
 
+// nextRequest waits for a request to the train, exiting if the simulation stops first
+func (t *Train) nextRequest() request {
+	select {
+	case req := <-t.requests:
+		return req
+	case <-t.graph.done:
+		t.graph.exit()
+		return request{}
+	}
 }
 
-func trainFromJSON(raw map[string]*json.RawMessage, junctions []*Junction,
-	context *Graph) *Train {
+func trainFromJSON(raw map[string]*json.RawMessage, base baseVehicle, freight bool, context *Graph,
+	path string, errs *ValidationErrors) *Train {
 
 	var stationNames []string
 	var train Train
-	json.Unmarshal(*raw["id"], &train.id)
-	json.Unmarshal(*raw["maxSpeed"], &train.maxSpeed)
-	json.Unmarshal(*raw["capacity"], &train.capacity)
-	json.Unmarshal(*raw["route"], &stationNames)
-	for _, stationName := range stationNames {
-		station := context.Stations[stationName]
+	train.baseVehicle = base
+	train.requests = make(chan request)
+	train.forced = make(chan *failureType)
+	train.delays = make(chan time.Duration)
+	ok := true
+	if !freight {
+		if !decodeField(raw, "capacity", &train.capacity, path, errs) {
+			ok = false
+		} else if train.capacity <= 0 {
+			errs.add(fieldPath(path, "capacity"), "must be positive")
+			ok = false
+		}
+	}
+	train.model = failureModelFromJSON(raw, path, errs)
+	if !decodeField(raw, "route", &stationNames, path, errs) {
+		return nil
+	}
+	routePath := fieldPath(path, "route")
+	if len(stationNames) < 2 {
+		errs.add(routePath, "route must contain at least 2 stations")
+		ok = false
+	}
+	for i, stationName := range stationNames {
+		station, exists := context.Stations[stationName]
+		if !exists {
+			errs.add(indexPath(routePath, i), "unknown station %q", stationName)
+			ok = false
+			continue
+		}
 		train.Route = append(train.Route, station)
+	}
+	if !ok {
+		return nil
+	}
+	for i, station := range train.Route {
+		next := train.Route[train.nextStationIdx(i)]
+		if _, _, reachable := context.shortestPath(station.A, next.A, train.maxSpeed, nil); !reachable {
+			errs.add(indexPath(routePath, i), "no path between stations %s and %s", station.name, next.name)
+			ok = false
+		}
+	}
+	if !ok {
+		return nil
+	}
+	train.Timetable = timetableFromJSON(raw, train.Route, path, errs)
+	if freight {
+		if train.cargo = cargoFromJSON(raw, train.Route, context, path, errs); train.cargo == nil {
+			return nil
+		}
+		return &train // passengers don't take freight trains
+	}
+	for _, station := range train.Route {
 		station.Trains[&train] = struct{}{}
 	}
-	train.comm = make(chan bool)
 	return &train
 }
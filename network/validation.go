@@ -0,0 +1,102 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ValidationError describes a single problem found in a network description
+type ValidationError struct {
+	Path    string // JSON path of the offending element, e.g. tracks[3].a
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+/*
+ValidationErrors lists all problems found while loading a network description.
+It is returned by LoadGraph as a single error.
+*/
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid network description (%d problems):\n\t%s",
+		len(errs), strings.Join(lines, "\n\t"))
+}
+
+func (errs *ValidationErrors) add(path string, format string, args ...interface{}) {
+	*errs = append(*errs, &ValidationError{path, fmt.Sprintf(format, args...)})
+}
+
+// err returns errs as an error, or nil if there are none
+func (errs ValidationErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func fieldPath(path string, name string) string {
+	return path + "." + name
+}
+
+func indexPath(path string, idx int) string {
+	return fmt.Sprintf("%s[%d]", path, idx)
+}
+
+/*
+decodeField unmarshals a required field of a JSON object into target.
+Returns false and records an error if the field is missing or malformed.
+*/
+func decodeField(raw map[string]*json.RawMessage, name string, target interface{},
+	path string, errs *ValidationErrors) bool {
+
+	if raw[name] == nil {
+		errs.add(fieldPath(path, name), "missing required field")
+		return false
+	}
+	return decodeOptionalField(raw, name, target, path, errs)
+}
+
+/*
+decodeOptionalField unmarshals a field of a JSON object into target, leaving
+it untouched if the field is missing. Returns false if the field is missing or malformed.
+*/
+func decodeOptionalField(raw map[string]*json.RawMessage, name string, target interface{},
+	path string, errs *ValidationErrors) bool {
+
+	if raw[name] == nil {
+		return false
+	}
+	if err := json.Unmarshal(*raw[name], target); err != nil {
+		errs.add(fieldPath(path, name), "%v", err)
+		return false
+	}
+	return true
+}
+
+/*
+decodeList unmarshals a required top-level list of JSON objects.
+Records an error and returns nil if it's missing or malformed.
+*/
+func decodeList(raw map[string]json.RawMessage, name string,
+	errs *ValidationErrors) []map[string]*json.RawMessage {
+
+	var list []map[string]*json.RawMessage
+	if raw[name] == nil {
+		errs.add(name, "missing required section")
+		return nil
+	}
+	if err := json.Unmarshal(raw[name], &list); err != nil {
+		errs.add(name, "%v", err)
+		return nil
+	}
+	return list
+}
@@ -3,8 +3,7 @@ package network
 import (
 	"encoding/json"
 	"fmt"
-	"log"
-	"strconv"
+	"math/rand"
 )
 
 type Vehicle interface {
@@ -13,12 +12,18 @@ type Vehicle interface {
 	// Handle implements the vehicle's logic and communication with network elements
 	Handle(graph *Graph)
 	ID() int
+	// backOff tells the vehicle to give up what it's waiting for, to resolve a deadlock
+	backOff()
 }
 
 type baseVehicle struct {
-	id       int
-	maxSpeed float64 // in km/h
-	comm     chan bool
+	id         int
+	maxSpeed   float64     // in km/h
+	kinematics *kinematics // nil if the vehicle changes speed instantly
+	comm       chan bool
+	backoff    chan bool // buffered, see backOff
+	rng        *rand.Rand
+	graph      *Graph
 }
 
 func (v *baseVehicle) ID() int {
@@ -29,26 +34,155 @@ func (v *baseVehicle) MaxSpeed() float64 {
 	return v.maxSpeed
 }
 
+// setUp prepares the vehicle for running in the simulation
+func (v *baseVehicle) setUp(graph *Graph) {
+	v.graph = graph
+	v.rng = graph.random(fmt.Sprintf("vehicle:%d", v.id))
+}
+
+/*
+request sends a request to target and waits for the response.
+If the simulation stops in the meantime, the vehicle's goroutine exits
+*/
 func (v *baseVehicle) request(target requestHandler, req requestType) bool {
-	target.getRequestChannel() <- request{v.comm, v.id, req}
-	return <-v.comm
+	return v.send(target, request{kind: req})
+}
+
+// send sends a request with additional data, see request
+func (v *baseVehicle) send(target requestHandler, req request) bool {
+	req.c, req.senderID = v.comm, v.id
+	select {
+	case target.getRequestChannel() <- req:
+	case <-v.graph.done:
+		v.graph.exit()
+	}
+	select {
+	case response := <-v.comm:
+		return response
+	case <-v.graph.done:
+		v.graph.exit()
+		return false
+	}
+}
+
+func (v *baseVehicle) backOff() {
+	select {
+	case v.backoff <- true:
+	default: // already told to
+	}
+}
+
+// backedOff checks whether the vehicle was told to back off since the last check
+func (v *baseVehicle) backedOff() bool {
+	select {
+	case <-v.backoff:
+		return true
+	default:
+		return false
+	}
 }
 
 func (v *baseVehicle) logf(format string, args ...interface{}) {
-	prefix := fmt.Sprintf("\x1b[3"+strconv.Itoa(v.id%9)+"m[Train #%d] ", v.id)
-	log.Printf(prefix+format+"\x1b[39m", args...)
+	v.graph.emit(&Message{
+		Source:  fmt.Sprintf("Train #%d", v.id),
+		Vehicle: v.id,
+		Text:    fmt.Sprintf(format, args...),
+	})
 }
 
-func vehicleFromJSON(raw map[string]*json.RawMessage, graph *Graph) Vehicle {
+// vehicleType returns the type of vehicle, as used in JSON
+func vehicleType(vehicle Vehicle) string {
+	switch v := vehicle.(type) {
+	case *Train:
+		if v.cargo != nil {
+			return "freight"
+		}
+		return "train"
+	case *RepairVehicle:
+		return "repair"
+	}
+	return "unknown"
+}
+
+/*
+vehicleSelection limits the vehicles taking part in the simulation, e.g. for debugging.
+A vehicle is selected if it matches any of the ids or types. Empty selection includes all vehicles.
+*/
+type vehicleSelection struct {
+	IDs   []int
+	Types []string
+}
+
+func (sel *vehicleSelection) includes(vehicle Vehicle) bool {
+	if len(sel.IDs) == 0 && len(sel.Types) == 0 {
+		return true
+	}
+	for _, id := range sel.IDs {
+		if vehicle.ID() == id {
+			return true
+		}
+	}
+	for _, kind := range sel.Types {
+		if vehicleType(vehicle) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that the selection refers only to existing vehicles and types
+func (sel *vehicleSelection) validate(vehicles []Vehicle, path string, errs *ValidationErrors) {
+	for i, id := range sel.IDs {
+		found := false
+		for _, vehicle := range vehicles {
+			found = found || vehicle.ID() == id
+		}
+		if !found {
+			errs.add(indexPath(fieldPath(path, "ids"), i), "unknown vehicle %d", id)
+		}
+	}
+	for i, kind := range sel.Types {
+		if kind != "train" && kind != "freight" && kind != "repair" {
+			errs.add(indexPath(fieldPath(path, "types"), i), "unknown vehicle type %q", kind)
+		}
+	}
+}
+
+func vehicleFromJSON(raw map[string]*json.RawMessage, graph *Graph,
+	path string, errs *ValidationErrors) Vehicle {
 
 	var kind string
-	var vehicle Vehicle
-	json.Unmarshal(*raw["type"], &kind)
+	var base baseVehicle
+	ok := decodeField(raw, "type", &kind, path, errs)
+	if !decodeField(raw, "id", &base.id, path, errs) {
+		ok = false
+	} else if base.id <= 0 {
+		errs.add(fieldPath(path, "id"), "must be positive")
+		ok = false
+	}
+	if !decodeField(raw, "maxSpeed", &base.maxSpeed, path, errs) {
+		ok = false
+	} else if base.maxSpeed <= 0 {
+		errs.add(fieldPath(path, "maxSpeed"), "must be positive")
+		ok = false
+	}
+	base.kinematics = kinematicsFromJSON(raw, path, errs)
+	if !ok {
+		return nil
+	}
+	base.comm = make(chan bool, 1) // buffered, so that handlers never block on responding
+	base.backoff = make(chan bool, 1)
 	switch kind {
-	case "train":
-		vehicle = trainFromJSON(raw, graph.Junctions, graph)
+	case "train", "freight":
+		if train := trainFromJSON(raw, base, kind == "freight", graph, path, errs); train != nil {
+			return train
+		}
 	case "repair":
-		vehicle = repairFromJSON(raw, graph.Tracks(), graph.Config)
+		if rv := repairFromJSON(raw, base, graph.Tracks(), path, errs); rv != nil {
+			return rv
+		}
+	default:
+		errs.add(fieldPath(path, "type"), "unknown vehicle type %q", kind)
 	}
-	return vehicle
+	return nil
 }
@@ -0,0 +1,46 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVehicleSelection(t *testing.T) {
+	vehicles := deadlockVehicles() // trains 1 and 2, repair crew 3 and freight train 4
+	tests := []struct {
+		name      string
+		selection vehicleSelection
+		included  string // ids of the selected vehicles
+		errors    string // paths of the reported problems
+	}{
+		{"all by default", vehicleSelection{}, "[1 2 3 4]", ""},
+		{"by id", vehicleSelection{IDs: []int{2, 4}}, "[2 4]", ""},
+		{"by type", vehicleSelection{Types: []string{"train"}}, "[1 2]", ""},
+		{"by id or type", vehicleSelection{IDs: []int{3}, Types: []string{"freight"}}, "[3 4]", ""},
+		{"unknown", vehicleSelection{IDs: []int{1, 9}, Types: []string{"tram", "repair"}}, "[1 3]",
+			"config.vehicles.ids[1], config.vehicles.types[0]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var included []int
+			for _, vehicle := range vehicles {
+				if test.selection.includes(vehicle) {
+					included = append(included, vehicle.ID())
+				}
+			}
+			if got := fmt.Sprint(included); got != test.included {
+				t.Errorf("selected %s, want %s", got, test.included)
+			}
+			errs := ValidationErrors{}
+			test.selection.validate(vehicles, "config.vehicles", &errs)
+			var paths []string
+			for _, err := range errs {
+				paths = append(paths, err.Path)
+			}
+			if got := strings.Join(paths, ", "); got != test.errors {
+				t.Errorf("errors at %q, want %q", got, test.errors)
+			}
+		})
+	}
+}
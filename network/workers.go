@@ -0,0 +1,182 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// worker lives at its home station and commutes by train to the tasks it's assigned to
+type worker struct {
+	id   int
+	home *Station
+}
+
+// passenger is a worker or another traveller riding a train, or waiting at a station for one
+type passenger struct {
+	worker    int         // 0 for travellers, see demand
+	trains    []Vehicle   // any of them takes the passenger where it's going
+	to        *Station    // where the passenger gets off
+	alighted  chan *Train // buffered, see Train.alight
+	boardedAt time.Duration
+}
+
+// takes checks whether the passenger boards train t
+func (p *passenger) takes(t *Train) bool {
+	for _, train := range p.trains {
+		if train == Vehicle(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ride is a single leg of a journey, on any of the trains serving both stations
+type ride struct {
+	from   *Station
+	to     *Station
+	trains []Vehicle
+}
+
+func (graph *Graph) loadWorkers(rawGroups []map[string]*json.RawMessage, errs *ValidationErrors) {
+	for i, raw := range rawGroups {
+		path := indexPath("workers", i)
+		var home string
+		var count int
+		ok := decodeField(raw, "home", &home, path, errs)
+		ok = decodeField(raw, "count", &count, path, errs) && ok
+		if !ok {
+			continue
+		}
+		station, exists := graph.Stations[home]
+		if !exists {
+			errs.add(fieldPath(path, "home"), "unknown station %q", home)
+			continue
+		}
+		if count <= 0 {
+			errs.add(fieldPath(path, "count"), "must be positive")
+			continue
+		}
+		for k := 0; k < count; k++ {
+			graph.workers = append(graph.workers, &worker{id: len(graph.workers) + 1, home: station})
+		}
+	}
+}
+
+/*
+run sends the worker to its tasks and back home, until the simulation is stopped. A worker unable
+to get to its task reports it to the job instead, and one unable to get back home stays where it
+is - its next journey starts there.
+*/
+func (w *worker) run(graph *Graph) {
+	at := w.home
+	for {
+		j := graph.jobs.next(w, graph)
+		arrivals := j.arrivals
+		if !w.travel(at, j.station, graph) {
+			arrivals = j.absent
+		}
+		select {
+		case arrivals <- w.id:
+		case <-graph.done:
+			graph.exit()
+		}
+		if arrivals == j.absent {
+			continue
+		}
+		select {
+		case <-j.finished:
+		case <-graph.done:
+			graph.exit()
+		}
+		at = j.station
+		if w.travel(j.station, w.home, graph) {
+			at = w.home
+		}
+	}
+}
+
+/*
+travel takes the worker from one station to another by train, changing trains on the way if needed.
+Returns false, without going anywhere, if no trains run between them.
+*/
+func (w *worker) travel(from *Station, to *Station, graph *Graph) bool {
+	journey, ok := graph.journey(from, to)
+	if !ok {
+		graph.emit(&Message{Source: "Jobs", Text: fmt.Sprintf("Worker %d can't get from %s to %s", w.id, from.name, to.name)})
+		return false
+	}
+	graph.travelBy(journey, w.id)
+	return true
+}
+
+/*
+travelBy takes a passenger along journey, getting on the first of the trains to come for each ride,
+and returns the time it spent waiting for them. worker is 0 for passengers other than workers.
+*/
+func (graph *Graph) travelBy(journey []ride, worker int) time.Duration {
+	var waiting time.Duration
+	for _, leg := range journey {
+		p := &passenger{worker: worker, trains: leg.trains, to: leg.to, alighted: make(chan *Train, 1)}
+		since := graph.Clock.Now()
+		leg.from.wait(p, graph)
+		select {
+		case <-p.alighted:
+		case <-graph.done:
+			graph.exit()
+		}
+		waiting += p.boardedAt - since
+	}
+	return waiting
+}
+
+/*
+journey plans the way between two stations with the fewest rides, see Graph.PlanJourney.
+Each ride may be taken on any of the running trains serving both of its stations.
+Returns false if there's no such way.
+*/
+func (graph *Graph) journey(from *Station, to *Station) ([]ride, bool) {
+	if from == to {
+		return nil, true
+	}
+	found := graph.itineraries(from, to, graph.Clock.Now())
+	if len(found) == 0 {
+		return nil, false
+	}
+	var rides []ride
+	for _, leg := range found[0].Legs {
+		start, end := graph.Stations[leg.From], graph.Stations[leg.To]
+		rides = append(rides, ride{start, end, graph.runningTrains(commonTrains(*start, *end))})
+	}
+	return rides, true
+}
+
+// runningTrains returns the trains taking part in the simulation, sorted by id
+func (graph *Graph) runningTrains(trains []Vehicle) []Vehicle {
+	var running []Vehicle
+	for _, train := range trains {
+		if graph.Config.Vehicles.includes(train) {
+			running = append(running, train)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].ID() < running[j].ID() })
+	return running
+}
+
+// workersReaching counts the workers able to get to station from their homes
+func (graph *Graph) workersReaching(station *Station) int {
+	reachable := make(map[*Station]bool)
+	count := 0
+	for _, w := range graph.workers {
+		ok, known := reachable[w.home]
+		if !known {
+			_, ok = graph.journey(w.home, station)
+			reachable[w.home] = ok
+		}
+		if ok {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	network "github.com/mregulski/ppt-6-concurrent/network"
+	"os"
+	"strings"
+)
+
+func planCommand(args []string) int {
+	flags := newFlagSet("plan", " from to")
+	networkFile := flags.String("network", "network.json", "network description")
+	at := flags.Duration("at", 0, "simulated time to leave at, e.g. 8h30m")
+	by := flags.String("by", "", "\"earliest\" arrival or fewest \"transfers\" (default: both)")
+	parseFlags(flags, args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitUsage
+	}
+	criteria := []string{network.EarliestArrival, network.FewestTransfers}
+	switch *by {
+	case "":
+	case network.EarliestArrival, network.FewestTransfers:
+		criteria = []string{*by}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown criterion %q, must be %q or %q\n",
+			*by, network.EarliestArrival, network.FewestTransfers)
+		return exitUsage
+	}
+	if *at < 0 {
+		fmt.Fprintln(os.Stderr, "-at must not be negative")
+		return exitUsage
+	}
+
+	graph, ok := loadNetwork(*networkFile)
+	if !ok {
+		return exitInvalid
+	}
+	var found []string
+	best := make(map[string][]string) // itinerary -> criteria it's the best one for
+	for _, criterion := range criteria {
+		itinerary, err := graph.PlanJourney(flags.Arg(0), flags.Arg(1), *at, criterion)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		text := itinerary.String()
+		if best[text] == nil {
+			found = append(found, text)
+		}
+		best[text] = append(best[text], criterion)
+	}
+	for _, text := range found {
+		fmt.Printf("%s\n  (%s)\n", text, strings.Join(best[text], ", "))
+	}
+	return exitOK
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	network "github.com/mregulski/ppt-6-concurrent/network"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+replayCommand reads an event log and prints the state of every Junction and Track at
+a given simulated time. Returns the process' exit code.
+*/
+func replayCommand(args []string) int {
+	flags := newFlagSet("replay", " events.jsonl")
+	at := flags.Duration("at", -1, "simulated time to reconstruct the state at, e.g. 36h30m (default: end of the log)")
+	networkFile := flags.String("network", "", "network description, to list elements that never appear in the log")
+	parseFlags(flags, args)
+	logFile := networkArg(flags)
+
+	file, err := os.Open(logFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	events, err := network.ReadEvents(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", logFile, err)
+		return exitFailure
+	}
+	if *at < 0 {
+		*at = 0
+		if len(events) > 0 {
+			*at = events[len(events)-1].Time()
+		}
+	}
+
+	state := network.Replay(events, *at)
+	if *networkFile != "" {
+		graph, ok := loadNetwork(*networkFile)
+		if !ok {
+			return exitInvalid
+		}
+		for _, junction := range graph.Junctions {
+			state.Location(junction.Name())
+		}
+		for _, track := range graph.Tracks() {
+			state.Location(track.Name())
+		}
+	}
+
+	fmt.Printf("State at %v\n", time.Duration(*at))
+	for _, name := range state.Names() {
+		fmt.Printf("%-16s %v\n", name, state.Locations[name])
+	}
+	failed := make([]string, 0, len(state.FailedVehicles))
+	for name := range state.FailedVehicles {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	for _, name := range failed {
+		fmt.Printf("%-16s FAILING\n", name)
+	}
+	return exitOK
+}